@@ -1,3 +1,6 @@
+// Package bot automates official Telegram bots, like SpyDefi, from a user account: starting
+// them, sending them messages, and handling their answers.
+// To run a bot account instead, log in with its token, see `session.ConnectBot`.
 package bot
 
 import (
@@ -6,30 +9,33 @@ import (
 	"math/rand"
 
 	"github.com/gotd/td/telegram"
-	"github.com/gotd/td/telegram/message"
 	"github.com/gotd/td/tg"
 
 	"github.com/prdsrm/std/messages"
+	"github.com/prdsrm/std/utils"
 )
 
+// Automation talks to an official bot from a user account, bot accounts can't start bots.
 type Automation struct {
 	*messages.Monitoring
-	ctx       context.Context
-	client    *tg.Client
-	Username  string
-	InputPeer tg.InputPeerClass
+	ctx           context.Context
+	client        *tg.Client
+	senderOptions []utils.SenderOption
+	Username      string
+	InputPeer     tg.InputPeerClass
 }
 
 // NewAutomation creates a new `Automation` object.
 // It requires an username, since all (official) Telegram Bot have an username.
-func NewAutomation(ctx context.Context, client *telegram.Client, dispatcher tg.UpdateDispatcher, username string, strip bool) (*Automation, error) {
-	sender := message.NewSender(client.API())
+// The sender options, like `utils.WithPeerStorage`, are used for every message sent to the bot.
+func NewAutomation(ctx context.Context, client *telegram.Client, dispatcher tg.UpdateDispatcher, username string, strip bool, opts ...utils.SenderOption) (*Automation, error) {
+	sender := utils.NewSender(client.API(), opts...)
 	builder := sender.Resolve(username)
 	inputPeer, err := builder.AsInputPeer(ctx)
 	if err != nil {
 		return nil, err
 	}
-	automation := Automation{Username: username, ctx: ctx, client: client.API(), InputPeer: inputPeer}
+	automation := Automation{Username: username, ctx: ctx, client: client.API(), senderOptions: opts, InputPeer: inputPeer}
 	id := inputPeer.(*tg.InputPeerUser).UserID
 	monitoring := messages.NewMonitoring(dispatcher, id, strip)
 	automation.Monitoring = monitoring
@@ -54,7 +60,7 @@ func (a *Automation) SendStartMessageWithParams(params string) error {
 }
 
 func (a *Automation) SendTextMessage(text string) error {
-	sender := message.NewSender(a.client)
+	sender := utils.NewSender(a.client, a.senderOptions...)
 	builder := sender.To(a.InputPeer)
 	_, err := builder.Text(a.ctx, text)
 	if err != nil {
@@ -73,7 +79,7 @@ func (a *Automation) SendCallbackData(msgID int, callbackData string) (*tg.Messa
 }
 
 func (a *Automation) ReplyToMessage(msgID int, text string) error {
-	sender := message.NewSender(a.client)
+	sender := utils.NewSender(a.client, a.senderOptions...)
 	builder := sender.Resolve(a.Username)
 	_, err := builder.Reply(msgID).Text(a.ctx, text)
 	if err != nil {
@@ -4,10 +4,10 @@ import (
 	"context"
 
 	"github.com/gotd/td/telegram"
-	"github.com/gotd/td/telegram/message"
 	"github.com/gotd/td/tg"
 
 	"github.com/prdsrm/std/messages"
+	"github.com/prdsrm/std/utils"
 )
 
 type ChannelMonitoring struct {
@@ -21,8 +21,8 @@ type ChannelMonitoring struct {
 // NOTE: The strip parameter means that you want to remove special characters and spaces from
 // messages you will be parsing, because you don't need them, and, its easier to make regular
 // expressions this way.
-func NewChannelMonitoring(ctx context.Context, client *telegram.Client, username string, dispatcher tg.UpdateDispatcher, strip bool) (*ChannelMonitoring, error) {
-	sender := message.NewSender(client.API())
+func NewChannelMonitoring(ctx context.Context, client *telegram.Client, username string, dispatcher tg.UpdateDispatcher, strip bool, opts ...utils.SenderOption) (*ChannelMonitoring, error) {
+	sender := utils.NewSender(client.API(), opts...)
 	builder := sender.Resolve(username)
 	_, err := builder.Join(ctx)
 	if err != nil {
@@ -4,12 +4,13 @@ import (
 	"context"
 
 	"github.com/gotd/td/telegram"
-	"github.com/gotd/td/telegram/message"
 	"github.com/gotd/td/tg"
+
+	"github.com/prdsrm/std/utils"
 )
 
-func GetSimilarChannels(ctx context.Context, client *telegram.Client, channelUsername string) ([]*tg.Channel, error) {
-	sender := message.NewSender(client.API())
+func GetSimilarChannels(ctx context.Context, client *telegram.Client, channelUsername string, opts ...utils.SenderOption) ([]*tg.Channel, error) {
+	sender := utils.NewSender(client.API(), opts...)
 	builder := sender.Resolve(channelUsername)
 	inputChannel, err := builder.AsInputChannelClass(ctx)
 	if err != nil {
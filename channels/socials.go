@@ -6,15 +6,14 @@ import (
 	"strings"
 
 	"github.com/gotd/td/telegram"
-	"github.com/gotd/td/telegram/message"
 	"github.com/gotd/td/tg"
 
 	"github.com/prdsrm/std/utils"
 )
 
 // JoinChannel joins a public channel / group chat, that has an username.
-func JoinChannel(ctx context.Context, client *telegram.Client, username string) error {
-	sender := message.NewSender(client.API())
+func JoinChannel(ctx context.Context, client *telegram.Client, username string, opts ...utils.SenderOption) error {
+	sender := utils.NewSender(client.API(), opts...)
 	builder := sender.Resolve(username)
 	_, err := builder.Join(ctx)
 	if err != nil {
@@ -33,12 +32,12 @@ func JoinPrivateChannel(ctx context.Context, client *telegram.Client, hash strin
 
 // AddView increments the number of view of a channel post.
 // It accepts a message deep link, such as: https://t.me/channel_username/<message_id: number>
-func AddView(ctx context.Context, client *telegram.Client, link string) error {
+func AddView(ctx context.Context, client *telegram.Client, link string, opts ...utils.SenderOption) error {
 	messageDeepLink, err := utils.ParseMessageDeepLink(link)
 	if err != nil {
 		return err
 	}
-	sender := message.NewSender(client.API())
+	sender := utils.NewSender(client.API(), opts...)
 	inputPeer, err := sender.Resolve(messageDeepLink.Username).AsInputPeer(ctx)
 	if err != nil {
 		return fmt.Errorf("couldn't resolve channel %s: %w", messageDeepLink.Username, err)
@@ -60,12 +59,12 @@ func toReactionClass(something tg.ReactionClass) tg.ReactionClass {
 
 // AddReaction adds a reaction as well as a view to the current post.
 // It accepts a message deep link, such as: https://t.me/channel_username/<message_id: number>
-func AddReaction(ctx context.Context, client *telegram.Client, link string, emojiChar string) error {
+func AddReaction(ctx context.Context, client *telegram.Client, link string, emojiChar string, opts ...utils.SenderOption) error {
 	messageDeepLink, err := utils.ParseMessageDeepLink(link)
 	if err != nil {
 		return err
 	}
-	sender := message.NewSender(client.API())
+	sender := utils.NewSender(client.API(), opts...)
 	inputPeer, err := sender.Resolve(messageDeepLink.Username).AsInputPeer(ctx)
 	if err != nil {
 		return err
@@ -94,12 +93,12 @@ func AddReaction(ctx context.Context, client *telegram.Client, link string, emoj
 }
 
 // ForwardMessageFromPublicChannel forwards a message from a public channel thanks to the message deep link
-func ForwardMessageFromPublicChannel(ctx context.Context, client *telegram.Client, deepLink string, destinationChannelUsername string) error {
+func ForwardMessageFromPublicChannel(ctx context.Context, client *telegram.Client, deepLink string, destinationChannelUsername string, opts ...utils.SenderOption) error {
 	messageDeepLink, err := utils.ParseMessageDeepLink(deepLink)
 	if err != nil {
 		return err
 	}
-	sender := message.NewSender(client.API())
+	sender := utils.NewSender(client.API(), opts...)
 	inputPeer, err := sender.Resolve(messageDeepLink.Username).AsInputPeer(ctx)
 	if err != nil {
 		return fmt.Errorf("can't get as input peer: %w", err)
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+
+	"github.com/prdsrm/std/session"
+	"github.com/prdsrm/std/session/postgres"
+)
+
+// databaseFlags adds the flags selecting the database to the flag set.
+func databaseFlags(flags *flag.FlagSet) (databaseURL *string, sqlitePath *string) {
+	databaseURL = flags.String("database", os.Getenv("DATABASE_URL"), "postgres database URL, DATABASE_URL by default")
+	sqlitePath = flags.String("sqlite", "", "SQLite database, instead of postgres")
+	return databaseURL, sqlitePath
+}
+
+// openStore opens and migrates the database, with the master key of STD_MASTER_KEY, if set.
+func openStore(databaseURL string, sqlitePath string) (*postgres.Store, error) {
+	encryption, err := postgres.DefaultEncryption()
+	if err != nil {
+		return nil, err
+	}
+	return openStoreWithEncryption(databaseURL, sqlitePath, encryption)
+}
+
+// openStoreWithEncryption opens and migrates the database, the values are stored in plain text
+// if encryption is nil.
+func openStoreWithEncryption(databaseURL string, sqlitePath string, encryption *postgres.Encryption) (*postgres.Store, error) {
+	switch {
+	case sqlitePath != "":
+		return postgres.OpenSQLiteStore(sqlitePath, postgres.WithEncryption(encryption))
+	case databaseURL != "":
+		db, err := postgres.OpenDBConnection(databaseURL)
+		if err != nil {
+			return nil, err
+		}
+		return postgres.NewStore(db, postgres.WithEncryption(encryption)), nil
+	default:
+		return nil, errors.New("-database, DATABASE_URL or -sqlite is required")
+	}
+}
+
+func dbImport(args []string) error {
+	flags := flag.NewFlagSet("db import", flag.ExitOnError)
+	databaseURL, sqlitePath := databaseFlags(flags)
+	jsonPath := flags.String("json", "", "accounts exported by db export, instead of a single account")
+	phone := flags.String("phone", "", "phone number of the account")
+	userID := flags.Int64("user-id", 0, "user ID of the account, required")
+	username := flags.String("username", "", "username of the account")
+	password := flags.String("password", "", "2FA password of the account")
+	title := flags.String("title", "", "title of the account")
+	apiID := flags.Int("api-id", session.TdesktopApiID, "API ID of the session")
+	apiHash := flags.String("api-hash", session.TdesktopApiHash, "API hash of the session")
+	proxy := flags.String("proxy", "", "proxy URL used by the account")
+	passcode := flags.String("passcode", "", "local passcode of the tdata folder")
+	tdlibKey := flags.String("tdlib-key", "", "database encryption key of the TDLib database, TDLib's default key if empty")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: std db import -json FILE\n       std db import -user-id ID [flags] SOURCE\n\nSOURCE is a session in any of the formats supported by std session convert.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if *jsonPath == "" && (flags.NArg() != 1 || *userID == 0) {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	store, err := openStore(*databaseURL, *sqlitePath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	if *jsonPath != "" {
+		content, err := os.ReadFile(*jsonPath)
+		if err != nil {
+			return err
+		}
+		var imported []postgres.Account
+		if err := json.Unmarshal(content, &imported); err != nil {
+			return err
+		}
+		if err := store.ImportAccounts(ctx, imported); err != nil {
+			return err
+		}
+		fmt.Printf("%d accounts imported\n", len(imported))
+		return nil
+	}
+
+	sessionData, err := session.OpenWithOptions(flags.Arg(0), session.OpenOptions{Passcode: *passcode, UserID: *userID, EncryptionKey: []byte(*tdlibKey)})
+	if err != nil {
+		return err
+	}
+	sessionString, err := session.Encode(sessionData, session.FormatTelethon)
+	if err != nil {
+		return err
+	}
+	config := session.Windows()
+	bot := &postgres.Bot{
+		UserID:      *userID,
+		PhoneNumber: *phone,
+		Username:    *username,
+		Password:    *password,
+		Title:       *title,
+	}
+	device := &postgres.Device{
+		BotUserID:      *userID,
+		ApiID:          *apiID,
+		ApiHash:        *apiHash,
+		SessionString:  sessionString,
+		DeviceModel:    config.DeviceModel,
+		SystemVersion:  config.SystemVersion,
+		AppVersion:     config.AppVersion,
+		LangPack:       config.LangPack,
+		LangCode:       config.LangCode,
+		SystemLangCode: config.SystemLangCode,
+		Proxy:          sql.NullString{String: *proxy, Valid: *proxy != ""},
+	}
+	if err := store.InsertBotWithDevice(ctx, bot, device); err != nil {
+		return err
+	}
+	fmt.Printf("account %d imported\n", *userID)
+	return nil
+}
+
+func dbExport(args []string) error {
+	flags := flag.NewFlagSet("db export", flag.ExitOnError)
+	databaseURL, sqlitePath := databaseFlags(flags)
+	flags.Parse(args)
+
+	store, err := openStore(*databaseURL, *sqlitePath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	exported, err := store.ExportAccounts(ctx)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(exported)
+}
+
+func dbCheck(args []string) error {
+	flags := flag.NewFlagSet("db check", flag.ExitOnError)
+	databaseURL, sqlitePath := databaseFlags(flags)
+	timeout := flags.Duration("timeout", 30*time.Second, "timeout of the connection to each account")
+	flags.Parse(args)
+
+	store, err := openStore(*databaseURL, *sqlitePath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	// The proxies are checked first, so the accounts fall back to the healthy ones.
+	if err := postgres.CheckProxiesContext(context.Background(), store.DB()); err != nil {
+		return err
+	}
+	proxies, err := postgres.GetAllProxiesContext(context.Background(), store.DB())
+	if err != nil {
+		return err
+	}
+	for _, proxy := range proxies {
+		if proxy.Healthy {
+			fmt.Printf("proxy %d\t%dms\n", proxy.ID, proxy.LatencyMs.Int64)
+		} else {
+			fmt.Printf("proxy %d\t%s\n", proxy.ID, proxy.LastError)
+		}
+	}
+
+	bots, err := store.GetAllBots(context.Background())
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for i := range bots {
+		bot := &bots[i]
+		// The health of the account is recorded by ConnectToBotContext.
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		err := postgres.ConnectToBotContext(ctx, store, bot, func(ctx context.Context, client *telegram.Client, dispatcher tg.UpdateDispatcher, options telegram.Options) error {
+			_, err := client.Self(ctx)
+			return err
+		})
+		cancel()
+		if err != nil {
+			failed++
+			fmt.Printf("%d\t%s\t%v\n", bot.UserID, bot.PhoneNumber, err)
+			continue
+		}
+		fmt.Printf("%d\t%s\tOK\n", bot.UserID, bot.PhoneNumber)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d accounts failed", failed, len(bots))
+	}
+	return nil
+}
+
+func dbEncrypt(args []string) error {
+	flags := flag.NewFlagSet("db encrypt", flag.ExitOnError)
+	databaseURL, sqlitePath := databaseFlags(flags)
+	keyFile := flags.String("key-file", "", "file of the master key, read from STD_MASTER_KEY by default")
+	oldKeyFile := flags.String("old-key-file", "", "file of the old master key when rotating, read from STD_OLD_MASTER_KEY by default, if set")
+	generate := flags.Bool("generate", false, "print a new master key, and exit")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: std db encrypt [-key-file FILE] [-old-key-file FILE]\n       std db encrypt -generate\n\nEncrypts the passwords and sessions stored in plain text with the master key, or rotates\nthe master key, when the old one is given.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *generate {
+		key, err := postgres.GenerateMasterKey()
+		if err != nil {
+			return err
+		}
+		fmt.Println(key)
+		return nil
+	}
+
+	key, err := readMasterKey(*keyFile, postgres.MasterKeyEnv)
+	if err != nil {
+		return fmt.Errorf("can't read master key: %w", err)
+	}
+	// Without old key, the rows are only encrypted, not re-encrypted.
+	var oldKeys [][]byte
+	if *oldKeyFile != "" || os.Getenv("STD_OLD_MASTER_KEY") != "" {
+		oldKey, err := readMasterKey(*oldKeyFile, "STD_OLD_MASTER_KEY")
+		if err != nil {
+			return fmt.Errorf("can't read old master key: %w", err)
+		}
+		oldKeys = append(oldKeys, oldKey)
+	}
+	encryption, err := postgres.NewEncryption(key, oldKeys...)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStoreWithEncryption(*databaseURL, *sqlitePath, encryption)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	updated, err := store.ReencryptRows(context.Background())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d rows encrypted\n", updated)
+	return nil
+}
+
+func readMasterKey(path string, env string) ([]byte, error) {
+	if path != "" {
+		return postgres.MasterKeyFromFile(path)
+	}
+	return postgres.MasterKeyFromEnv(env)
+}
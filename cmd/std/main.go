@@ -0,0 +1,63 @@
+// Command std manages sessions, and the accounts stored in the database.
+//
+//	std session login [-phone PHONE | -qr | -bot-token TOKEN] [-to FORMAT] [-out PATH]
+//	std session convert [-from FORMAT] [-to FORMAT] [-out PATH] [-passcode PASSCODE] [-tdlib-key KEY] SOURCE
+//	std session inspect [-passcode PASSCODE] [-tdlib-key KEY] SOURCE
+//	std db import [-json FILE | -phone PHONE ... SOURCE]
+//	std db export
+//	std db check
+//	std db encrypt [-key-file FILE] [-old-key-file FILE] [-generate]
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const usage = `usage: std <command> <subcommand> [flags]
+
+session commands:
+  session login     log in to an account, and print its session
+  session convert   convert a session to another format
+  session inspect   print the DC, user ID and auth key ID of a session
+
+db commands, the database is DATABASE_URL, or -sqlite, and STD_MASTER_KEY decrypts it:
+  db import         insert an account, or the accounts exported by db export
+  db export         print all the accounts and their devices as JSON
+  db check          check the proxies of the pool, connect to every account, and report their status
+  db encrypt        encrypt the passwords and sessions with the master key, or rotate it
+
+Run std <command> <subcommand> -h for the flags of a subcommand.
+`
+
+func main() {
+	if len(os.Args) < 3 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var commands map[string]func(args []string) error
+	switch os.Args[1] {
+	case "session":
+		commands = map[string]func(args []string) error{
+			"login":   sessionLogin,
+			"convert": sessionConvert,
+			"inspect": sessionInspect,
+		}
+	case "db":
+		commands = map[string]func(args []string) error{
+			"import":  dbImport,
+			"export":  dbExport,
+			"check":   dbCheck,
+			"encrypt": dbEncrypt,
+		}
+	}
+	command, ok := commands[os.Args[2]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command: %s %s\n\n%s", os.Args[1], os.Args[2], usage)
+		os.Exit(2)
+	}
+	if err := command(os.Args[3:]); err != nil {
+		fmt.Fprintf(os.Stderr, "std %s %s: %v\n", os.Args[1], os.Args[2], err)
+		os.Exit(1)
+	}
+}
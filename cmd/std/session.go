@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+
+	examples "github.com/gotd/td/examples"
+	tdsession "github.com/gotd/td/session"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/telegram/auth"
+	"github.com/gotd/td/tg"
+
+	"github.com/prdsrm/std/session"
+)
+
+const outputFormats = "telethon, pyrogram, gramjs or gotd, or sqlite and tdata with -out"
+
+func sessionLogin(args []string) error {
+	flags := flag.NewFlagSet("session login", flag.ExitOnError)
+	phone := flags.String("phone", "", "phone number, prompted if empty")
+	to := flags.String("to", string(session.FormatTelethon), "format of the session: "+outputFormats)
+	out := flags.String("out", "", "file, or tdata folder, to write the session to, instead of printing it")
+	apiID := flags.Int("api-id", session.TdesktopApiID, "API ID")
+	apiHash := flags.String("api-hash", session.TdesktopApiHash, "API hash")
+	proxy := flags.String("proxy", "", "proxy URL")
+	qrCode := flags.Bool("qr", false, "log in by scanning a QR code from the app of the account, instead of a login code")
+	password := flags.String("password", "", "2FA password, for -qr")
+	botToken := flags.String("bot-token", "", "token of a bot account to log in to, instead of a phone number")
+	flags.Parse(args)
+
+	// Checked before logging in, the session would be lost otherwise.
+	if err := checkOutput(session.Format(*to), *out); err != nil {
+		return err
+	}
+	if *qrCode {
+		result, err := session.QRLogin(context.Background(), session.QRLoginOptions{
+			Device:   session.Windows(),
+			APIID:    *apiID,
+			APIHash:  *apiHash,
+			Proxy:    *proxy,
+			Password: session.DefaultAuthConversator{Passwd: *password},
+			Out:      os.Stderr,
+		})
+		if err != nil {
+			return err
+		}
+		return writeSession(&session.Session{Data: result.Data, UserID: result.UserID, APIID: *apiID}, session.Format(*to), *out)
+	}
+
+	login := func(ctx context.Context, client *telegram.Client, dispatcher tg.UpdateDispatcher, options telegram.Options) error {
+		self, err := client.Self(ctx)
+		if err != nil {
+			return err
+		}
+		loader := &tdsession.Loader{Storage: options.SessionStorage}
+		data, err := loader.Load(ctx)
+		if err != nil {
+			return err
+		}
+		return writeSession(&session.Session{Data: data, UserID: self.ID, APIID: *apiID, Bot: self.Bot}, session.Format(*to), *out)
+	}
+	if *botToken != "" {
+		return session.ConnectBot(login, *apiID, *apiHash, *botToken, *proxy, nil)
+	}
+	flow := auth.NewFlow(examples.Terminal{PhoneNumber: *phone}, auth.SendCodeOptions{})
+	return session.Connect(login, session.Windows(), *apiID, *apiHash, "", *proxy, flow)
+}
+
+func sessionConvert(args []string) error {
+	flags := flag.NewFlagSet("session convert", flag.ExitOnError)
+	from := flags.String("from", "", "format of the source, detected if empty")
+	to := flags.String("to", string(session.FormatTelethon), "format of the converted session: "+outputFormats)
+	out := flags.String("out", "", "file, or tdata folder, to write the session to, instead of printing it")
+	userID := flags.Int64("user-id", 0, "user ID of the account, selecting it in a tdata folder with several accounts, and required by pyrogram and tdata when the source doesn't store it")
+	passcode := flags.String("passcode", "", "local passcode of the tdata folder")
+	tdlibKey := flags.String("tdlib-key", "", "database encryption key of the TDLib database, TDLib's default key if empty")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: std session convert [flags] SOURCE\n\nSOURCE is a string session, a session file, a tdata folder or a TDLib database.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if err := checkOutput(session.Format(*to), *out); err != nil {
+		return err
+	}
+	sessionString, err := session.ConvertWithOptions(flags.Arg(0), session.Format(*to), session.ConvertOptions{
+		OpenOptions: session.OpenOptions{Format: session.Format(*from), Passcode: *passcode, UserID: *userID, EncryptionKey: []byte(*tdlibKey)},
+		Out:         *out,
+	})
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		fmt.Println(sessionString)
+	}
+	return nil
+}
+
+func sessionInspect(args []string) error {
+	flags := flag.NewFlagSet("session inspect", flag.ExitOnError)
+	userID := flags.Int64("user-id", 0, "user ID of the account to inspect, in a tdata folder with several accounts")
+	passcode := flags.String("passcode", "", "local passcode of the tdata folder")
+	tdlibKey := flags.String("tdlib-key", "", "database encryption key of the TDLib database, TDLib's default key if empty")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: std session inspect [flags] SOURCE")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	s, err := session.OpenSession(flags.Arg(0), session.OpenOptions{Passcode: *passcode, UserID: *userID, EncryptionKey: []byte(*tdlibKey)})
+	if err != nil {
+		return err
+	}
+	fmt.Println("Format:     ", s.Format)
+	fmt.Println("DC:         ", s.Data.DC)
+	fmt.Println("Address:    ", s.Data.Addr)
+	if s.UserID != 0 {
+		fmt.Println("User ID:    ", s.UserID)
+	} else {
+		fmt.Println("User ID:     unknown, not stored in this format")
+	}
+	fmt.Println("Auth key ID:", hex.EncodeToString(s.Data.AuthKeyID))
+	return nil
+}
+
+// writeSession prints the session in the given format, or writes it to the output file.
+func writeSession(s *session.Session, to session.Format, out string) error {
+	if out != "" {
+		return s.Write(to, out)
+	}
+	if err := checkOutput(to, out); err != nil {
+		return err
+	}
+	sessionString, err := s.Encode(to)
+	if err != nil {
+		return err
+	}
+	fmt.Println(sessionString)
+	return nil
+}
+
+// checkOutput returns an error if the format is written to a file, and no output file is given.
+func checkOutput(to session.Format, out string) error {
+	if out == "" && (to == session.FormatSQLite || to == session.FormatTDATA) {
+		return fmt.Errorf("-out is required to write a %s session", to)
+	}
+	return nil
+}
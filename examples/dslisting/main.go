@@ -2,10 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"os"
+	"os/signal"
 	"regexp"
 	"strings"
+	"syscall"
 
 	"github.com/gotd/td/telegram"
 	"github.com/gotd/td/tg"
@@ -94,7 +97,14 @@ func main() {
 	password := os.Getenv("PASSWORD")
 	sessionString := os.Getenv("SESSION_STRING")
 	flow := session.GetNewDefaultAuthConversator(phone, password)
-	err := session.Connect(listen, session.Windows(), 2040, "b18441a1ff607e10a989891a5462e627", sessionString, "", flow)
+	// Stop listening on Ctrl+C or SIGTERM.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err := session.ConnectContext(ctx, listen, session.Windows(), 2040, "b18441a1ff607e10a989891a5462e627", sessionString, "", flow)
+	if errors.Is(err, session.ConnectionCancelledError) {
+		log.Println("Stopped listening")
+		return
+	}
 	if err != nil {
 		log.Fatalln("can't connect: ", err)
 	}
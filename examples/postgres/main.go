@@ -31,16 +31,22 @@ func main() {
 	if !exists {
 		log.Fatalln("DATABASE_URL is not set")
 	}
+	// Passwords and sessions are encrypted at rest when STD_MASTER_KEY is set, see std db encrypt.
+	encryption, err := postgres.DefaultEncryption()
+	if err != nil {
+		log.Fatalln("invalid master key: ", err)
+	}
 	db, err := postgres.OpenDBConnection(connStr)
 	if err != nil {
 		log.Fatalln("can't connect to database: ", err)
 	}
-	bot, err := postgres.GetBotByUserID(db, int64(id))
+	store := postgres.NewStore(db, postgres.WithEncryption(encryption))
+	bot, err := store.GetBot(context.Background(), int64(id))
 	if err != nil {
 		log.Fatalln("can't get bot: ", err)
 	}
 	log.Println("Bot from the db: ", bot.UserID)
-	err = postgres.ConnectToBotFromDatabase(db, bot, listen)
+	err = postgres.ConnectToBot(store, bot, listen)
 	if err != nil {
 		log.Fatalln("can't connect: ", err)
 	}
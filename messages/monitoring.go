@@ -31,8 +31,12 @@ func (a *Monitoring) SetupMessageMonitoring(messagesChan chan *tg.Message) {
 			// Outgoing message, not interesting.
 			return nil
 		}
-		messagesChan <- m
-		return nil
+		select {
+		case messagesChan <- m:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	})
 	a.dispatcher.OnNewMessage(func(ctx context.Context, entities tg.Entities, u *tg.UpdateNewMessage) error {
 		m, ok := u.Message.(*tg.Message)
@@ -40,8 +44,12 @@ func (a *Monitoring) SetupMessageMonitoring(messagesChan chan *tg.Message) {
 			// Outgoing message, not interesting.
 			return nil
 		}
-		messagesChan <- m
-		return nil
+		select {
+		case messagesChan <- m:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	})
 	a.dispatcher.OnNewChannelMessage(func(ctx context.Context, entities tg.Entities, u *tg.UpdateNewChannelMessage) error {
 		m, ok := u.Message.(*tg.Message)
@@ -49,8 +57,12 @@ func (a *Monitoring) SetupMessageMonitoring(messagesChan chan *tg.Message) {
 			// Outgoing message, not interesting.
 			return nil
 		}
-		messagesChan <- m
-		return nil
+		select {
+		case messagesChan <- m:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	})
 }
 
@@ -85,7 +97,12 @@ func (m *Monitoring) Listen(ctx context.Context, client *telegram.Client) error
 	messagesChan := make(chan *tg.Message)
 	m.SetupMessageMonitoring(messagesChan)
 	for {
-		msg := <-messagesChan
+		var msg *tg.Message
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg = <-messagesChan:
+		}
 		id := utils.GetIDFromPeerClass(msg.PeerID)
 		if id == m.id {
 			ctx := MonitoringContext{
@@ -0,0 +1,390 @@
+package session
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/tg"
+)
+
+// ServiceNotificationsUserID is the ID of the Telegram account sending the login codes.
+const ServiceNotificationsUserID = 777000
+
+// DefaultCodePollInterval is how often the providers polling for the code check for it.
+const DefaultCodePollInterval = time.Second
+
+var (
+	// CodeNotExpectedError is returned to the HTTP callback when no login is waiting for a code.
+	CodeNotExpectedError = errors.New("no login code is expected")
+)
+
+// CodeProvider provides the login code sent by Telegram, used by DefaultAuthConversator.
+type CodeProvider interface {
+	Code(ctx context.Context, sentCode *tg.AuthSentCode) (string, error)
+}
+
+// CodeProviderFunc is a function implementing CodeProvider.
+type CodeProviderFunc func(ctx context.Context, sentCode *tg.AuthSentCode) (string, error)
+
+func (f CodeProviderFunc) Code(ctx context.Context, sentCode *tg.AuthSentCode) (string, error) {
+	return f(ctx, sentCode)
+}
+
+// CodeRequestObserver is implemented by the code providers which need to know when the code is
+// requested, like ServiceNotificationCodeProvider. DefaultAuthConversator calls CodeRequested just
+// before the code is sent, the login fails if it returns an error.
+type CodeRequestObserver interface {
+	CodeRequested(ctx context.Context) error
+}
+
+// AnyCodeProvider returns the first code given by one of the providers, so the code can be
+// typed in the terminal, or sent to the HTTP callback, for example.
+func AnyCodeProvider(providers ...CodeProvider) CodeProvider {
+	return anyCodeProvider(providers)
+}
+
+type anyCodeProvider []CodeProvider
+
+func (providers anyCodeProvider) Code(ctx context.Context, sentCode *tg.AuthSentCode) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		code string
+		err  error
+	}
+	results := make(chan result, len(providers))
+	for _, provider := range providers {
+		go func(provider CodeProvider) {
+			code, err := provider.Code(ctx, sentCode)
+			results <- result{code, err}
+		}(provider)
+	}
+	var errs []error
+	for range providers {
+		r := <-results
+		if r.err == nil {
+			return r.code, nil
+		}
+		errs = append(errs, r.err)
+	}
+	return "", errors.Join(errs...)
+}
+
+func (providers anyCodeProvider) CodeRequested(ctx context.Context) error {
+	var errs []error
+	for _, provider := range providers {
+		if observer, ok := provider.(CodeRequestObserver); ok {
+			errs = append(errs, observer.CodeRequested(ctx))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// StdinCodeProvider asks for the code in the terminal.
+// The input is read by a single goroutine, started by the first call, so a line typed after a
+// cancelled call is given to the next one.
+type StdinCodeProvider struct {
+	// In and Out are os.Stdin and os.Stdout by default.
+	In  io.Reader
+	Out io.Writer
+
+	once  sync.Once
+	lines chan stdinLine
+}
+
+type stdinLine struct {
+	line string
+	err  error
+}
+
+func (p *StdinCodeProvider) Code(ctx context.Context, sentCode *tg.AuthSentCode) (string, error) {
+	p.once.Do(p.start)
+	out := p.Out
+	if out == nil {
+		out = os.Stdout
+	}
+	fmt.Fprint(out, "Enter code: ")
+	select {
+	case l, ok := <-p.lines:
+		if !ok {
+			return "", io.EOF
+		}
+		return l.line, l.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// start reads the lines in the background, since reading can't be cancelled. Each line is only
+// read once the previous one is taken.
+func (p *StdinCodeProvider) start() {
+	in := p.In
+	if in == nil {
+		in = os.Stdin
+	}
+	p.lines = make(chan stdinLine)
+	go func() {
+		defer close(p.lines)
+		reader := bufio.NewReader(in)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil && (line == "" || !errors.Is(err, io.EOF)) {
+				p.lines <- stdinLine{err: err}
+				return
+			}
+			p.lines <- stdinLine{line: strings.TrimSpace(line)}
+			if err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// HTTPCodeProvider waits for the code to be posted to its HTTP handler, in the `code` form
+// value, like `curl -d code=12345 http://host/code`.
+// Codes posted while no login is waiting are refused, so an old code is never used.
+type HTTPCodeProvider struct {
+	codes chan string
+}
+
+func NewHTTPCodeProvider() *HTTPCodeProvider {
+	return &HTTPCodeProvider{codes: make(chan string)}
+}
+
+func (p *HTTPCodeProvider) Code(ctx context.Context, sentCode *tg.AuthSentCode) (string, error) {
+	select {
+	case code := <-p.codes:
+		return code, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (p *HTTPCodeProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	code := strings.TrimSpace(r.FormValue("code"))
+	if code == "" {
+		http.Error(w, "code is required", http.StatusBadRequest)
+		return
+	}
+	select {
+	case p.codes <- code:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, CodeNotExpectedError.Error(), http.StatusConflict)
+	}
+}
+
+// FileCodeProvider reads the code from a file, or a named pipe.
+// A file is polled until it's written, and removed once read, so the code isn't used twice.
+// A named pipe is read until the first line, like `echo 12345 > pipe`.
+type FileCodeProvider struct {
+	Path string
+	// PollInterval is DefaultCodePollInterval by default.
+	PollInterval time.Duration
+}
+
+func (p FileCodeProvider) Code(ctx context.Context, sentCode *tg.AuthSentCode) (string, error) {
+	if info, err := os.Stat(p.Path); err == nil && info.Mode()&os.ModeNamedPipe != 0 {
+		return p.readPipe(ctx)
+	}
+	interval := p.PollInterval
+	if interval == 0 {
+		interval = DefaultCodePollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		content, err := os.ReadFile(p.Path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		if code := strings.TrimSpace(string(content)); code != "" {
+			if err := os.Remove(p.Path); err != nil {
+				return "", err
+			}
+			return code, nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+func (p FileCodeProvider) readPipe(ctx context.Context) (string, error) {
+	// Opened for writing too, so the open doesn't block until a writer comes, and the reader
+	// doesn't get EOF between writers.
+	pipe, err := os.OpenFile(p.Path, os.O_RDWR, 0)
+	if err != nil {
+		return "", err
+	}
+	defer pipe.Close()
+	stop := context.AfterFunc(ctx, func() {
+		pipe.Close()
+	})
+	defer stop()
+	line, err := bufio.NewReader(pipe).ReadString('\n')
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// ServiceNotificationCodeProvider reads the code from the messages sent by Telegram, in the
+// service notifications chat (777000), of another session of the account already logged in.
+// Only the messages received since the code was requested are read, see CodeRequestObserver, or
+// since the code is asked for, when used without DefaultAuthConversator.
+// The messages are told apart by ID, not by date, so the clock of the host doesn't matter.
+type ServiceNotificationCodeProvider struct {
+	// API is the client of the logged in session.
+	API *tg.Client
+	// PollInterval is DefaultCodePollInterval by default.
+	PollInterval time.Duration
+
+	mux       sync.Mutex
+	requested bool
+	// lastID is the ID of the newest message when the code was requested.
+	lastID int
+}
+
+// CodeRequested records the newest message of the chat, older messages contain older codes.
+func (p *ServiceNotificationCodeProvider) CodeRequested(ctx context.Context) error {
+	peer, err := p.serviceNotificationsPeer(ctx)
+	if err != nil {
+		return err
+	}
+	lastID, err := p.lastMessageID(ctx, peer)
+	if err != nil {
+		return err
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.requested = true
+	p.lastID = lastID
+	return nil
+}
+
+func (p *ServiceNotificationCodeProvider) Code(ctx context.Context, sentCode *tg.AuthSentCode) (string, error) {
+	p.mux.Lock()
+	requested, lastID := p.requested, p.lastID
+	p.requested = false
+	p.mux.Unlock()
+	peer, err := p.serviceNotificationsPeer(ctx)
+	if err != nil {
+		return "", err
+	}
+	if !requested {
+		lastID, err = p.lastMessageID(ctx, peer)
+		if err != nil {
+			return "", err
+		}
+	}
+	interval := p.PollInterval
+	if interval == 0 {
+		interval = DefaultCodePollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		// Only the messages newer than lastID are returned, sorted from the newest.
+		messages, err := p.history(ctx, peer, lastID, 5)
+		if err != nil {
+			return "", err
+		}
+		for _, m := range messages {
+			message, ok := m.(*tg.Message)
+			if !ok || message.ID <= lastID {
+				continue
+			}
+			if code, ok := extractLoginCode(message.Message, sentCode); ok {
+				return code, nil
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// lastMessageID returns the ID of the newest message of the chat, 0 if it's empty.
+func (p *ServiceNotificationCodeProvider) lastMessageID(ctx context.Context, peer tg.InputPeerClass) (int, error) {
+	messages, err := p.history(ctx, peer, 0, 1)
+	if err != nil || len(messages) == 0 {
+		return 0, err
+	}
+	return messages[0].GetID(), nil
+}
+
+// history returns the newest messages of the chat, with an ID greater than minID.
+func (p *ServiceNotificationCodeProvider) history(ctx context.Context, peer tg.InputPeerClass, minID int, limit int) ([]tg.MessageClass, error) {
+	history, err := p.API.MessagesGetHistory(ctx, &tg.MessagesGetHistoryRequest{Peer: peer, MinID: minID, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	modified, ok := history.AsModified()
+	if !ok {
+		return nil, fmt.Errorf("unexpected history type %T", history)
+	}
+	return modified.GetMessages(), nil
+}
+
+// serviceNotificationsPeer finds the access hash of the service notifications chat in the
+// dialogs, since it has no username to resolve.
+func (p *ServiceNotificationCodeProvider) serviceNotificationsPeer(ctx context.Context) (tg.InputPeerClass, error) {
+	dialogs, err := p.API.MessagesGetDialogs(ctx, &tg.MessagesGetDialogsRequest{
+		OffsetPeer: &tg.InputPeerEmpty{},
+		Limit:      100,
+	})
+	if err != nil {
+		return nil, err
+	}
+	modified, ok := dialogs.AsModified()
+	if !ok {
+		return nil, fmt.Errorf("unexpected dialogs type %T", dialogs)
+	}
+	for _, u := range modified.GetUsers() {
+		if user, ok := u.(*tg.User); ok && user.ID == ServiceNotificationsUserID {
+			return user.AsInputPeer(), nil
+		}
+	}
+	return nil, errors.New("service notifications chat not found in the dialogs")
+}
+
+var loginCodeRegexp = regexp.MustCompile(`\b\d{5,6}\b`)
+
+// extractLoginCode finds the login code in a service notification, of the length of the sent
+// code when it's known.
+func extractLoginCode(message string, sentCode *tg.AuthSentCode) (string, bool) {
+	length := 0
+	if sentCode != nil {
+		if app, ok := sentCode.Type.(*tg.AuthSentCodeTypeApp); ok {
+			length = app.Length
+		}
+	}
+	for _, code := range loginCodeRegexp.FindAllString(message, -1) {
+		if length == 0 || len(code) == length {
+			return code, true
+		}
+	}
+	return "", false
+}
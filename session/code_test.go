@@ -0,0 +1,198 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/tg"
+)
+
+func TestStdinCodeProvider(t *testing.T) {
+	in, writer := io.Pipe()
+	provider := &StdinCodeProvider{In: in, Out: io.Discard}
+
+	// A cancelled call doesn't take the next line.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := provider.Code(ctx, &tg.AuthSentCode{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Both lines are kept, even when they're read at once.
+	go writer.Write([]byte(" 12345\n54321\n"))
+	for _, expected := range []string{"12345", "54321"} {
+		code, err := provider.Code(context.Background(), &tg.AuthSentCode{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != expected {
+			t.Fatalf("unexpected code: %q instead of %q", code, expected)
+		}
+	}
+	writer.Close()
+	if _, err := provider.Code(context.Background(), &tg.AuthSentCode{}); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
+
+func TestHTTPCodeProvider(t *testing.T) {
+	provider := NewHTTPCodeProvider()
+	server := httptest.NewServer(provider)
+	defer server.Close()
+
+	post := func(code string) int {
+		resp, err := http.PostForm(server.URL, url.Values{"code": {code}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := post("11111"); status != http.StatusConflict {
+		t.Fatalf("code accepted while not expected: %d", status)
+	}
+
+	codes := make(chan string, 1)
+	go func() {
+		code, _ := provider.Code(context.Background(), &tg.AuthSentCode{})
+		codes <- code
+	}()
+	// Posted until the provider is waiting for it.
+	for post("12345") == http.StatusConflict {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if code := <-codes; code != "12345" {
+		t.Fatalf("unexpected code: %q", code)
+	}
+	if status := post(""); status != http.StatusBadRequest {
+		t.Fatalf("empty code accepted: %d", status)
+	}
+}
+
+func TestFileCodeProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "code")
+	provider := FileCodeProvider{Path: path, PollInterval: 10 * time.Millisecond}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		os.WriteFile(path, []byte("12345\n"), 0o600)
+	}()
+	code, err := provider.Code(context.Background(), &tg.AuthSentCode{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != "12345" {
+		t.Fatalf("unexpected code: %q", code)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("code file not removed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := provider.Code(ctx, &tg.AuthSentCode{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestAnyCodeProvider(t *testing.T) {
+	failing := CodeProviderFunc(func(ctx context.Context, sentCode *tg.AuthSentCode) (string, error) {
+		return "", CodeRequiredError
+	})
+	waiting := CodeProviderFunc(func(ctx context.Context, sentCode *tg.AuthSentCode) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+	static := CodeProviderFunc(func(ctx context.Context, sentCode *tg.AuthSentCode) (string, error) {
+		return "12345", nil
+	})
+	code, err := AnyCodeProvider(failing, waiting, static).Code(context.Background(), &tg.AuthSentCode{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != "12345" {
+		t.Fatalf("unexpected code: %q", code)
+	}
+	if _, err := AnyCodeProvider(failing, failing).Code(context.Background(), &tg.AuthSentCode{}); !errors.Is(err, CodeRequiredError) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestExtractLoginCode(t *testing.T) {
+	message := "Login code: 54321. Do not give this code to anyone, even if they say they are from Telegram!\n\nIf you didn't request this code, ignore this message. Account ID 1234567890."
+	code, ok := extractLoginCode(message, &tg.AuthSentCode{Type: &tg.AuthSentCodeTypeApp{Length: 5}})
+	if !ok || code != "54321" {
+		t.Fatalf("unexpected code: %q", code)
+	}
+	if _, ok := extractLoginCode("New login. Dear user, we detected a login into your account.", &tg.AuthSentCode{}); ok {
+		t.Fatal("code found in a message without code")
+	}
+}
+
+// serviceNotifications answers the requests of ServiceNotificationCodeProvider with its
+// messages, sorted from the newest.
+type serviceNotifications struct {
+	mux      sync.Mutex
+	messages []tg.MessageClass
+}
+
+func (n *serviceNotifications) add(message *tg.Message) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.messages = append([]tg.MessageClass{message}, n.messages...)
+}
+
+func (n *serviceNotifications) Invoke(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	switch request := input.(type) {
+	case *tg.MessagesGetDialogsRequest:
+		output.(*tg.MessagesDialogsBox).Dialogs = &tg.MessagesDialogs{
+			Users: []tg.UserClass{&tg.User{ID: ServiceNotificationsUserID, AccessHash: 1}},
+		}
+	case *tg.MessagesGetHistoryRequest:
+		var messages []tg.MessageClass
+		for _, m := range n.messages {
+			if m.GetID() > request.MinID && len(messages) < request.Limit {
+				messages = append(messages, m)
+			}
+		}
+		output.(*tg.MessagesMessagesBox).Messages = &tg.MessagesMessages{Messages: messages}
+	default:
+		return fmt.Errorf("unexpected request %T", input)
+	}
+	return nil
+}
+
+func TestServiceNotificationCodeRequested(t *testing.T) {
+	ctx := context.Background()
+	// The dates are ahead of the host clock, only the IDs tell the new messages apart.
+	notifications := &serviceNotifications{}
+	notifications.add(&tg.Message{ID: 10, Date: int(time.Now().Add(time.Hour).Unix()), Message: "Login code: 11111."})
+	provider := &ServiceNotificationCodeProvider{API: tg.NewClient(notifications), PollInterval: 10 * time.Millisecond}
+	conversator := DefaultAuthConversator{CodeProvider: AnyCodeProvider(provider, &StdinCodeProvider{})}
+	if _, err := conversator.Phone(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Codes sent before the request are old ones.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		notifications.add(&tg.Message{ID: 11, Date: int(time.Now().Add(-time.Hour).Unix()), Message: "Login code: 22222."})
+	}()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	code, err := provider.Code(ctx, &tg.AuthSentCode{Type: &tg.AuthSentCodeTypeApp{Length: 5}})
+	if err != nil || code != "22222" {
+		t.Fatalf("unexpected code: %q, %v", code, err)
+	}
+}
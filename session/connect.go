@@ -7,19 +7,21 @@ import (
 	"golang.org/x/time/rate"
 	"log"
 	"sync"
-	"time"
 
 	"github.com/gotd/td/session"
 	"github.com/prdsrm/std/utils"
 
 	"github.com/gotd/contrib/middleware/floodwait"
 	"github.com/gotd/contrib/middleware/ratelimit"
+	peerstorage "github.com/gotd/contrib/storage"
 	"github.com/gotd/td/telegram"
 	"github.com/gotd/td/telegram/auth"
 	"github.com/gotd/td/telegram/dcs"
 	"github.com/gotd/td/telegram/updates"
 	updhook "github.com/gotd/td/telegram/updates/hook"
 	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 )
 
 // MemorySession implements in-memory session storage.
@@ -55,72 +57,283 @@ func (s *MemorySession) StoreSession(ctx context.Context, data []byte) error {
 	return nil
 }
 
-func GetNewDefaultAuthConversator(phone string, password string) auth.Flow {
+// GetNewDefaultAuthConversator returns the authentication flow of the account.
+// The login code is asked to the code providers, the first code given by one of them is used.
+// Without provider, only existing sessions can be used, and a new login fails with
+// CodeRequiredError.
+func GetNewDefaultAuthConversator(phone string, password string, codeProviders ...CodeProvider) auth.Flow {
 	userAuthenticator := DefaultAuthConversator{PhoneNumber: phone, Passwd: password}
+	switch len(codeProviders) {
+	case 0:
+	case 1:
+		userAuthenticator.CodeProvider = codeProviders[0]
+	default:
+		userAuthenticator.CodeProvider = AnyCodeProvider(codeProviders...)
+	}
 	authOpt := auth.SendCodeOptions{}
 	// Authentication flow handles authentication process, like prompting for code and 2FA password.
 	flow := auth.NewFlow(userAuthenticator, authOpt)
 	return flow
 }
 
+// ConnectOptions configures ConnectWithOptions.
+// Zero values use the same defaults as Connect.
+type ConnectOptions struct {
+	Device        telegram.DeviceConfig
+	APIID         int
+	APIHash       string
+	SessionString string
+	Proxy         string
+	Flow          auth.Flow
+	// BotToken logs in to a bot account, from @BotFather, instead of using the Flow.
+	// Bot accounts can't use every method, like getting dialogs, or starting bots with
+	// `bot.Automation`, which automates official bots from a user account.
+	BotToken string
+	// Storage keeps the session, in memory by default.
+	// The session string is only used when the storage is empty.
+	Storage session.Storage
+	// RateLimit is the rate of requests, one every 100ms by default, with RateBurst requests
+	// at once, 5 by default.
+	RateLimit        rate.Limit
+	RateBurst        int
+	DisableRateLimit bool
+	// DisableFloodWait disables waiting and retrying requests on FLOOD_WAIT errors.
+	DisableFloodWait bool
+	// Middlewares are added after the default ones.
+	Middlewares []telegram.Middleware
+	// Logger is used by the client and the updates manager, logs are discarded by default.
+	Logger *zap.Logger
+	// UpdateHandler receives every update, after the dispatcher. Setting it starts the updates
+	// manager, which fetches the updates missed while the connection was lost.
+	UpdateHandler telegram.UpdateHandler
+	// DCList is the list of datacenters, production ones by default, and PublicKeys the keys
+	// of their servers, the production ones by default.
+	DCList     dcs.List
+	PublicKeys []telegram.PublicKey
+	// UpdatesStorage keeps the updates state (pts, qts, seq) and AccessHasher the channels
+	// access hashes, in memory by default. Setting it starts the updates manager, and once
+	// persisted, like in an `UpdatesStorage` with a path, the updates missed while the client was
+	// stopped are fetched on the next start.
+	// AccessHasher defaults to UpdatesStorage when it stores the access hashes too, like
+	// `UpdatesStorage` does.
+	// Without UpdatesStorage and UpdateHandler, the updates manager isn't started, and the updates
+	// are passed to the dispatcher as they come.
+	UpdatesStorage updates.StateStorage
+	AccessHasher   updates.ChannelAccessHasher
+	// PeerStorage collects the peers seen in updates. Pass it to the senders with
+	// `utils.WithPeerStorage`, so they look up usernames in it before resolving them.
+	PeerStorage peerstorage.PeerStorage
+}
+
+const (
+	DefaultRateLimit = rate.Limit(10) // one request every 100ms
+	DefaultRateBurst = 5
+)
+
 func Connect(f func(ctx context.Context, client *telegram.Client, dispatcher tg.UpdateDispatcher, options telegram.Options) error, device telegram.DeviceConfig, apiID int, apiHash string, sessionString string, proxy string, flow auth.Flow) error {
-	storage := &MemorySession{}
-	// We only load the session if it isn't empty
-	if sessionString != "" {
-		loader := session.Loader{Storage: storage}
-		// Extracts session data from Telethon session string.
-		data, err := session.TelethonSession(sessionString)
-		if err != nil {
-			return err
-		}
-		// Save decoded Telethon session as gotd session.
-		if err := loader.Save(context.Background(), data); err != nil {
-			return err
+	return ConnectWithOptions(context.Background(), f, ConnectOptions{
+		Device:        device,
+		APIID:         apiID,
+		APIHash:       apiHash,
+		SessionString: sessionString,
+		Proxy:         proxy,
+		Flow:          flow,
+	})
+}
+
+// ConnectBot is like Connect, but logs in to the bot account of the token, and keeps the
+// session in the given storage, in memory if nil, so the bot isn't logged in at every start.
+func ConnectBot(f func(ctx context.Context, client *telegram.Client, dispatcher tg.UpdateDispatcher, options telegram.Options) error, apiID int, apiHash string, botToken string, proxy string, storage session.Storage) error {
+	return ConnectWithOptions(context.Background(), f, ConnectOptions{
+		APIID:    apiID,
+		APIHash:  apiHash,
+		BotToken: botToken,
+		Proxy:    proxy,
+		Storage:  storage,
+	})
+}
+
+// ConnectWithOptions connects to the account, and runs f once the account is authorized.
+// The connection, the updates manager and f are stopped when the context is cancelled, see
+// ConnectContext.
+func ConnectWithOptions(ctx context.Context, f func(ctx context.Context, client *telegram.Client, dispatcher tg.UpdateDispatcher, options telegram.Options) error, opts ConnectOptions) error {
+	conn, err := newConnection(opts)
+	if err != nil {
+		return err
+	}
+	if err := loadSessionString(ctx, conn.options.SessionStorage, opts.SessionString); err != nil {
+		return err
+	}
+
+	run := func(ctx context.Context) error {
+		// Spawning main goroutine.
+		return runClient(f, ctx, conn.client, conn.dispatcher, conn.options, opts.Flow, opts.BotToken, conn.gaps, conn.flusher)
+	}
+	if conn.waiter != nil {
+		run = func(ctx context.Context) error {
+			return conn.waiter.Run(ctx, func(ctx context.Context) error {
+				return runClient(f, ctx, conn.client, conn.dispatcher, conn.options, opts.Flow, opts.BotToken, conn.gaps, conn.flusher)
+			})
 		}
 	}
+	return cancellationError(ctx, run(ctx))
+}
+
+// cancellationError returns ConnectionCancelledError when the connection was stopped by the
+// context. The client may return nil then, while still dialing. Other errors are kept, they
+// happened before, or regardless of the cancellation.
+func cancellationError(ctx context.Context, err error) error {
+	if ctx.Err() != nil && (err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+		return fmt.Errorf("%w: %w", ConnectionCancelledError, ctx.Err())
+	}
+	return err
+}
+
+// connection is the client configured by the options, with the managers running alongside it.
+type connection struct {
+	client     *telegram.Client
+	options    telegram.Options
+	dispatcher tg.UpdateDispatcher
+	// waiter retries the requests on FLOOD_WAIT errors, nil if DisableFloodWait is set.
+	waiter *floodwait.Waiter
+	// gaps is the updates manager, nil unless UpdatesStorage or UpdateHandler is set.
+	gaps *updates.Manager
+	// flusher writes the updates state once gaps is stopped, nil unless UpdatesStorage batches
+	// its writes, like `UpdatesStorage`.
+	flusher updatesFlusher
+}
+
+// updatesFlusher is implemented by the updates storages batching their writes.
+type updatesFlusher interface {
+	Flush() error
+}
+
+// channelAccessHasher returns the access hasher of the updates manager, defaulting to the updates
+// storage so the access hashes are persisted with the state, and channel gaps can be fetched after
+// a restart.
+func channelAccessHasher(opts ConnectOptions) updates.ChannelAccessHasher {
+	if opts.AccessHasher != nil {
+		return opts.AccessHasher
+	}
+	hasher, _ := opts.UpdatesStorage.(updates.ChannelAccessHasher)
+	return hasher
+}
+
+// newConnection applies the defaults of the options, and creates the client, without connecting.
+func newConnection(opts ConnectOptions) (*connection, error) {
+	storage := opts.Storage
+	if storage == nil {
+		storage = &MemorySession{}
+	}
 	// Load proxy
 	var resolver dcs.Resolver
 	var err error
-	if proxy != "" {
-		resolver, err = utils.NewResolver(proxy)
+	if opts.Proxy != "" {
+		resolver, err = utils.NewResolver(opts.Proxy)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 	// Finish setting up options
 	options := telegram.Options{
 		Resolver:       resolver,
 		SessionStorage: storage,
-		Device:         device,
+		Device:         opts.Device,
+		DCList:         opts.DCList,
+		PublicKeys:     opts.PublicKeys,
+		Logger:         opts.Logger,
 	}
-	waiter := floodwait.NewWaiter()
 	// Dispatcher handles incoming updates.
 	dispatcher := tg.NewUpdateDispatcher()
-	gaps := updates.New(updates.Config{
-		Handler: dispatcher,
-	})
-	options.Middlewares = []telegram.Middleware{
+	var handler telegram.UpdateHandler = dispatcher
+	if opts.UpdateHandler != nil {
+		handler = telegram.UpdateHandlerFunc(func(ctx context.Context, u tg.UpdatesClass) error {
+			if err := dispatcher.Handle(ctx, u); err != nil {
+				return err
+			}
+			return opts.UpdateHandler.Handle(ctx, u)
+		})
+	}
+	if opts.PeerStorage != nil {
+		handler = peerstorage.UpdateHook(handler, opts.PeerStorage)
+	}
+	// The updates manager is only started by the callers opting in to the updates recovery.
+	var gaps *updates.Manager
+	if opts.UpdatesStorage != nil || opts.UpdateHandler != nil {
+		gaps = updates.New(updates.Config{
+			Handler:      handler,
+			Storage:      opts.UpdatesStorage,
+			AccessHasher: channelAccessHasher(opts),
+			Logger:       opts.Logger,
+		})
+		handler = gaps
+	}
+	var waiter *floodwait.Waiter
+	if !opts.DisableFloodWait {
 		// Setting up FLOOD_WAIT handler to automatically wait and retry request.
-		waiter,
+		waiter = floodwait.NewWaiter()
+		options.Middlewares = append(options.Middlewares, waiter)
+	}
+	if !opts.DisableRateLimit {
+		limit, burst := opts.RateLimit, opts.RateBurst
+		if limit == 0 {
+			limit = DefaultRateLimit
+		}
+		if burst == 0 {
+			burst = DefaultRateBurst
+		}
 		// Setting up general rate limits to less likely get flood wait errors.
-		ratelimit.New(rate.Every(time.Millisecond*100), 5),
-		// Setting up update hook
-		updhook.UpdateHook(gaps.Handle),
+		options.Middlewares = append(options.Middlewares, ratelimit.New(limit, burst))
 	}
-	options.UpdateHandler = gaps
-	client := telegram.NewClient(apiID, apiHash, options)
+	// Setting up update hook
+	options.Middlewares = append(options.Middlewares, updhook.UpdateHook(handler.Handle))
+	options.Middlewares = append(options.Middlewares, opts.Middlewares...)
+	options.UpdateHandler = handler
+	flusher, _ := opts.UpdatesStorage.(updatesFlusher)
+	return &connection{
+		client:     telegram.NewClient(opts.APIID, opts.APIHash, options),
+		options:    options,
+		dispatcher: dispatcher,
+		waiter:     waiter,
+		gaps:       gaps,
+		flusher:    flusher,
+	}, nil
+}
 
-	ctx := context.Background()
-	if err := waiter.Run(ctx, func(ctx context.Context) error {
-		// Spawning main goroutine.
-		return runClient(f, ctx, client, dispatcher, options, flow)
-	}); err != nil {
+// loadSessionString saves the string session, whatever its format, in the storage, unless the
+// storage already has a session, which may be newer.
+func loadSessionString(ctx context.Context, storage session.Storage, sessionString string) error {
+	if sessionString == "" {
+		return nil
+	}
+	_, err := storage.LoadSession(ctx)
+	if !errors.Is(err, session.ErrNotFound) {
 		return err
 	}
-	return nil
+	data, err := ParseSessionString(sessionString)
+	if err != nil {
+		return err
+	}
+	loader := session.Loader{Storage: storage}
+	return loader.Save(ctx, data)
+}
+
+// ConnectContext is like Connect, but the connection, the updates manager and f are stopped
+// when the context is cancelled.
+// In this case, the returned error wraps ConnectionCancelledError and the context error, unless
+// the connection or f failed with another error.
+func ConnectContext(ctx context.Context, f func(ctx context.Context, client *telegram.Client, dispatcher tg.UpdateDispatcher, options telegram.Options) error, device telegram.DeviceConfig, apiID int, apiHash string, sessionString string, proxy string, flow auth.Flow) error {
+	return ConnectWithOptions(ctx, f, ConnectOptions{
+		Device:        device,
+		APIID:         apiID,
+		APIHash:       apiHash,
+		SessionString: sessionString,
+		Proxy:         proxy,
+		Flow:          flow,
+	})
 }
 
-func runClient(f func(ctx context.Context, client *telegram.Client, dispatcher tg.UpdateDispatcher, options telegram.Options) error, ctx context.Context, client *telegram.Client, dispatcher tg.UpdateDispatcher, options telegram.Options, flow auth.Flow) error {
+func runClient(f func(ctx context.Context, client *telegram.Client, dispatcher tg.UpdateDispatcher, options telegram.Options) error, ctx context.Context, client *telegram.Client, dispatcher tg.UpdateDispatcher, options telegram.Options, flow auth.Flow, botToken string, gaps *updates.Manager, flusher updatesFlusher) error {
 	if err := client.Run(ctx, func(ctx context.Context) error {
 		authCli := client.Auth()
 		// Checking auth status.
@@ -130,39 +343,82 @@ func runClient(f func(ctx context.Context, client *telegram.Client, dispatcher t
 		}
 		// Can be already authenticated if we have valid session in
 		// session storage.
+		self := status.User
 		if !status.Authorized {
-			if err := client.Auth().IfNecessary(ctx, flow); err != nil {
-				return fmt.Errorf("could not authenticate: %w", err)
+			if botToken != "" {
+				_, err = client.Auth().Bot(ctx, botToken)
+			} else {
+				err = client.Auth().IfNecessary(ctx, flow)
+			}
+			if err != nil {
+				return fmt.Errorf("could not authenticate: %w: %w", NotAuthorizedError, err)
 			}
 			// In the telegram/connect.go, line 31, we can see that the client.Run helper does not correctly check
 			// for the session authorization.
 			// It doesn't return anything if its unauthorized, it doesn't log, because its in a goroutine. We need to check ourselves.
-			self, err := client.Self(ctx)
+			self, err = client.Self(ctx)
 			if err != nil {
 				if auth.IsUnauthorized(err) {
-					return fmt.Errorf("could not authenticate, client is not authorized: %w", err)
+					return fmt.Errorf("could not authenticate: %w: %w", NotAuthorizedError, err)
 				}
 				return err
 			}
 			log.Println("Logged in to account", self.ID, self.FirstName, self.LastName)
 		}
-		if err := f(ctx, client, dispatcher, options); err != nil {
-			return err
-		}
-		return nil
+		return runWithUpdates(ctx, f, client, dispatcher, options, gaps, flusher, self)
 	}); err != nil {
 		return err
 	}
 	return nil
 }
 
+// runWithUpdates runs the updates manager, if any, while f is running.
+// The updates manager is stopped, and its state saved and flushed, before returning, whether f
+// returned or the context was cancelled.
+func runWithUpdates(ctx context.Context, f func(ctx context.Context, client *telegram.Client, dispatcher tg.UpdateDispatcher, options telegram.Options) error, client *telegram.Client, dispatcher tg.UpdateDispatcher, options telegram.Options, gaps *updates.Manager, flusher updatesFlusher, self *tg.User) error {
+	if gaps == nil {
+		return f(ctx, client, dispatcher, options)
+	}
+	g, ctx := errgroup.WithContext(ctx)
+	gapsCtx, stopGaps := context.WithCancel(ctx)
+	defer stopGaps()
+	g.Go(func() error {
+		defer gaps.Reset()
+		err := gaps.Run(gapsCtx, client.API(), self.ID, updates.AuthOptions{IsBot: self.Bot})
+		if err != nil && gapsCtx.Err() != nil && ctx.Err() == nil {
+			// Stopped because f returned.
+			return nil
+		}
+		return err
+	})
+	g.Go(func() error {
+		defer stopGaps()
+		return f(ctx, client, dispatcher, options)
+	})
+	err := g.Wait()
+	if flusher != nil {
+		if flushErr := flusher.Flush(); flushErr != nil {
+			return errors.Join(err, flushErr)
+		}
+	}
+	return err
+}
+
 var (
 	CodeRequiredError = errors.New("code is required")
+	// ConnectionCancelledError is returned when the connection is stopped by its context, it
+	// wraps the context error.
+	ConnectionCancelledError = errors.New("connection cancelled")
+	// NotAuthorizedError is returned when the session isn't authorized, and logging in failed,
+	// it wraps the login error.
+	NotAuthorizedError = errors.New("not authorized")
 )
 
 type DefaultAuthConversator struct {
 	PhoneNumber string
 	Passwd      string
+	// CodeProvider provides the login code, CodeRequiredError is returned without it.
+	CodeProvider CodeProvider
 }
 
 func (DefaultAuthConversator) SignUp(ctx context.Context) (auth.UserInfo, error) {
@@ -173,12 +429,22 @@ func (DefaultAuthConversator) AcceptTermsOfService(ctx context.Context, tos tg.H
 	return &auth.SignUpRequired{TermsOfService: tos}
 }
 
-func (k DefaultAuthConversator) Phone(_ context.Context) (string, error) {
+// Phone is called just before the code is sent, so the CodeProvider is told the code is
+// requested, if it's a CodeRequestObserver.
+func (k DefaultAuthConversator) Phone(ctx context.Context) (string, error) {
+	if observer, ok := k.CodeProvider.(CodeRequestObserver); ok {
+		if err := observer.CodeRequested(ctx); err != nil {
+			return "", err
+		}
+	}
 	return k.PhoneNumber, nil
 }
 
 func (k DefaultAuthConversator) Code(ctx context.Context, sentCode *tg.AuthSentCode) (string, error) {
-	return "", CodeRequiredError
+	if k.CodeProvider == nil {
+		return "", CodeRequiredError
+	}
+	return k.CodeProvider.Code(ctx, sentCode)
 }
 
 func (k DefaultAuthConversator) Password(ctx context.Context) (string, error) {
@@ -2,14 +2,24 @@ package session
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"os"
 	"regexp"
 	"testing"
+	"time"
 
+	"github.com/gotd/contrib/middleware/floodwait"
+	"github.com/gotd/contrib/middleware/ratelimit"
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/session"
 	"github.com/gotd/td/telegram"
 	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+	"golang.org/x/time/rate"
 
 	"github.com/prdsrm/std/bot"
 	"github.com/prdsrm/std/channels"
@@ -60,3 +70,296 @@ func TestConnect(t *testing.T) {
 		t.Fatalf("can't continue: %s", err.Error())
 	}
 }
+
+func TestConnectBot(t *testing.T) {
+	botToken := os.Getenv("BOT_TOKEN")
+	if botToken == "" {
+		t.Skip("BOT_TOKEN is not set")
+	}
+	err := ConnectBot(func(ctx context.Context, client *telegram.Client, dispatcher tg.UpdateDispatcher, options telegram.Options) error {
+		self, err := client.Self(ctx)
+		if err != nil {
+			return err
+		}
+		if !self.Bot {
+			return fmt.Errorf("logged in to user %d, not a bot", self.ID)
+		}
+		return nil
+	}, TdesktopApiID, TdesktopApiHash, botToken, "", nil)
+	if err != nil {
+		t.Fatalf("can't continue: %s", err.Error())
+	}
+}
+
+// invokeThrough returns the invoker calling next through the middlewares, in the client order.
+func invokeThrough(middlewares []telegram.Middleware, next tg.Invoker) tg.Invoker {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		next = middlewares[i].Handle(next)
+	}
+	return next
+}
+
+func TestNewConnectionDefaults(t *testing.T) {
+	conn, err := newConnection(ConnectOptions{APIID: TdesktopApiID, APIHash: TdesktopApiHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := conn.options.SessionStorage.(*MemorySession); !ok {
+		t.Fatalf("unexpected session storage: %T", conn.options.SessionStorage)
+	}
+	if conn.options.Resolver != nil {
+		t.Fatal("unexpected resolver without proxy")
+	}
+	middlewares := conn.options.Middlewares
+	if len(middlewares) != 3 {
+		t.Fatalf("unexpected middlewares: %d", len(middlewares))
+	}
+	if waiter, ok := middlewares[0].(*floodwait.Waiter); !ok || waiter != conn.waiter {
+		t.Fatalf("the flood wait middleware isn't first: %T", middlewares[0])
+	}
+	if _, ok := middlewares[1].(*ratelimit.RateLimiter); !ok {
+		t.Fatalf("the rate limit middleware isn't second: %T", middlewares[1])
+	}
+	// The updates are passed to the dispatcher, without updates manager.
+	if conn.gaps != nil {
+		t.Fatal("unexpected updates manager")
+	}
+	if _, ok := conn.options.UpdateHandler.(tg.UpdateDispatcher); !ok {
+		t.Fatalf("unexpected update handler: %T", conn.options.UpdateHandler)
+	}
+}
+
+func TestNewConnectionUpdatesManager(t *testing.T) {
+	for _, opts := range []ConnectOptions{
+		{UpdatesStorage: &UpdatesStorage{}},
+		{UpdateHandler: telegram.UpdateHandlerFunc(func(ctx context.Context, u tg.UpdatesClass) error {
+			return nil
+		})},
+	} {
+		conn, err := newConnection(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conn.gaps == nil || conn.options.UpdateHandler != conn.gaps {
+			t.Fatalf("the updates manager isn't the update handler: %T", conn.options.UpdateHandler)
+		}
+	}
+}
+
+func TestChannelAccessHasher(t *testing.T) {
+	storage := &UpdatesStorage{}
+	if hasher := channelAccessHasher(ConnectOptions{UpdatesStorage: storage}); hasher != storage {
+		t.Fatalf("the updates storage isn't the access hasher: %T", hasher)
+	}
+	other := &UpdatesStorage{}
+	if hasher := channelAccessHasher(ConnectOptions{UpdatesStorage: storage, AccessHasher: other}); hasher != other {
+		t.Fatal("the access hasher option isn't used")
+	}
+	if hasher := channelAccessHasher(ConnectOptions{}); hasher != nil {
+		t.Fatalf("unexpected access hasher: %T", hasher)
+	}
+}
+
+func TestNewConnectionWithoutMiddlewares(t *testing.T) {
+	var calls int
+	custom := telegram.MiddlewareFunc(func(next tg.Invoker) telegram.InvokeFunc {
+		return func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+			calls++
+			return next.Invoke(ctx, input, output)
+		}
+	})
+	storage := &MemorySession{}
+	conn, err := newConnection(ConnectOptions{
+		Storage:          storage,
+		DisableRateLimit: true,
+		DisableFloodWait: true,
+		Middlewares:      []telegram.Middleware{custom},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.options.SessionStorage != storage {
+		t.Fatal("the storage isn't used")
+	}
+	if conn.waiter != nil {
+		t.Fatal("unexpected flood wait waiter")
+	}
+	for _, middleware := range conn.options.Middlewares {
+		switch middleware.(type) {
+		case *floodwait.Waiter, *ratelimit.RateLimiter:
+			t.Fatalf("unexpected middleware: %T", middleware)
+		}
+	}
+	invoker := invokeThrough(conn.options.Middlewares, telegram.InvokeFunc(func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+		return nil
+	}))
+	if err := invoker.Invoke(context.Background(), &tg.HelpGetConfigRequest{}, &tg.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Fatalf("the custom middleware was called %d times", calls)
+	}
+}
+
+func TestConnectionRateLimit(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		opts     ConnectOptions
+		burst    int
+		interval time.Duration
+	}{
+		{name: "default", burst: DefaultRateBurst, interval: 100 * time.Millisecond},
+		{name: "custom", opts: ConnectOptions{RateLimit: rate.Limit(5), RateBurst: 2}, burst: 2, interval: 200 * time.Millisecond},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			test.opts.DisableFloodWait = true
+			conn, err := newConnection(test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			invoker := invokeThrough(conn.options.Middlewares, telegram.InvokeFunc(func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+				return nil
+			}))
+			start := time.Now()
+			for i := 0; i < test.burst; i++ {
+				if err := invoker.Invoke(context.Background(), &tg.HelpGetConfigRequest{}, &tg.Config{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if elapsed := time.Since(start); elapsed > test.interval/2 {
+				t.Fatalf("the burst was rate limited: %v", elapsed)
+			}
+			if err := invoker.Invoke(context.Background(), &tg.HelpGetConfigRequest{}, &tg.Config{}); err != nil {
+				t.Fatal(err)
+			}
+			if elapsed := time.Since(start); elapsed < test.interval/2 {
+				t.Fatalf("the request after the burst wasn't rate limited: %v", elapsed)
+			}
+		})
+	}
+}
+
+func TestConnectionFloodWait(t *testing.T) {
+	conn, err := newConnection(ConnectOptions{DisableRateLimit: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls int
+	invoker := invokeThrough(conn.options.Middlewares, telegram.InvokeFunc(func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+		calls++
+		if calls == 1 {
+			return tgerr.New(420, "FLOOD_WAIT_1")
+		}
+		return nil
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = conn.waiter.Run(ctx, func(ctx context.Context) error {
+		return invoker.Invoke(ctx, &tg.HelpGetConfigRequest{}, &tg.Config{})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Fatalf("the request was sent %d times", calls)
+	}
+}
+
+func TestLoadSessionString(t *testing.T) {
+	ctx := context.Background()
+	first, err := EncodeSessionToTelethonString(&session.Data{DC: 2, Addr: "149.154.167.51:443", AuthKey: testAuthKey()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := EncodeSessionToTelethonString(&session.Data{DC: 4, Addr: "149.154.167.91:443", AuthKey: testAuthKey()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage := &MemorySession{}
+	if err := loadSessionString(ctx, storage, first); err != nil {
+		t.Fatal(err)
+	}
+	// The storage already has a session, which may be newer than the string.
+	if err := loadSessionString(ctx, storage, second); err != nil {
+		t.Fatal(err)
+	}
+	loader := session.Loader{Storage: storage}
+	data, err := loader.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.DC != 2 {
+		t.Fatalf("the stored session was replaced: DC %d", data.DC)
+	}
+}
+
+func TestConnectCancelled(t *testing.T) {
+	// A proxy which never answers, so the client is still dialing when it's cancelled.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+
+	called := func(ctx context.Context, client *telegram.Client, dispatcher tg.UpdateDispatcher, options telegram.Options) error {
+		return errors.New("connected through a proxy which never answers")
+	}
+	for _, test := range []struct {
+		name  string
+		delay time.Duration
+	}{
+		{name: "before dialing"},
+		{name: "while dialing", delay: 200 * time.Millisecond},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			if test.delay == 0 {
+				cancel()
+			} else {
+				time.AfterFunc(test.delay, cancel)
+			}
+			done := make(chan error, 1)
+			go func() {
+				done <- ConnectContext(ctx, called, Windows(), TdesktopApiID, TdesktopApiHash, "", "http://"+listener.Addr().String(), GetNewDefaultAuthConversator("", ""))
+			}()
+			select {
+			case err := <-done:
+				if !errors.Is(err, ConnectionCancelledError) || !errors.Is(err, context.Canceled) {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("the connection wasn't stopped by the context")
+			}
+		})
+	}
+}
+
+func TestCancellationError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	failed := errors.New("failed")
+	if err := cancellationError(ctx, failed); err != failed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cancel()
+	for _, err := range []error{nil, context.Canceled, fmt.Errorf("dial: %w", context.Canceled)} {
+		if err := cancellationError(ctx, err); !errors.Is(err, ConnectionCancelledError) || !errors.Is(err, context.Canceled) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// Real failures are kept, even when the context is cancelled.
+	if err := cancellationError(ctx, failed); err != failed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,349 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gotd/td/session"
+)
+
+// Format is a session format supported by this package.
+type Format string
+
+const (
+	// FormatTelethon is a Telethon string session.
+	FormatTelethon Format = "telethon"
+	// FormatPyrogram is a Pyrogram string session.
+	FormatPyrogram Format = "pyrogram"
+	// FormatGramJS is a GramJS / Telegram Web string session.
+	FormatGramJS Format = "gramjs"
+	// FormatGotd is the JSON session stored by gotd's `session.Storage`.
+	FormatGotd Format = "gotd"
+	// FormatSQLite is a Telethon SQLite session file.
+	FormatSQLite Format = "sqlite"
+	// FormatTDATA is a Telegram Desktop tdata folder.
+	FormatTDATA Format = "tdata"
+	// FormatTDLib is a TDLib database, or its binlog file, usually `td.binlog`.
+	FormatTDLib Format = "tdlib"
+)
+
+var (
+	UnknownFormatError     = errors.New("unknown session format")
+	UnsupportedFormatError = errors.New("unsupported session format")
+	MultipleAccountsError  = errors.New("several accounts found, the user ID of the account is required")
+	UserIDRequiredError    = errors.New("the user ID of the account is required")
+)
+
+// OpenOptions are the options of OpenWithOptions, only used by the formats which need them.
+type OpenOptions struct {
+	// Format is the expected format of the source, it's detected if empty.
+	Format Format
+	// Passcode is the local passcode of a tdata folder, empty if it has none.
+	Passcode string
+	// UserID selects the account of a tdata folder containing several accounts, and is the user ID
+	// of the session when its format doesn't store it.
+	UserID int64
+	// EncryptionKey is the `database_encryption_key` of a TDLib database, TDLib's default key is
+	// used if empty.
+	EncryptionKey []byte
+}
+
+var sqliteMagic = []byte("SQLite format 3\x00")
+
+// Detect returns the format of the session, which can be a string session, or the path to a
+// Telethon SQLite session, a gotd JSON session, a tdata folder, or a TDLib database.
+func Detect(source string) (Format, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return detectString(source)
+	}
+	if info.IsDir() {
+		if isTDATADirectory(source) {
+			return FormatTDATA, nil
+		}
+		if _, err := os.Stat(filepath.Join(source, tdlibBinlogName)); err == nil {
+			return FormatTDLib, nil
+		}
+		return "", fmt.Errorf("%w: %s is not a tdata folder or a TDLib database", UnknownFormatError, source)
+	}
+	content, err := os.ReadFile(source)
+	if err != nil {
+		return "", err
+	}
+	if bytes.HasPrefix(content, sqliteMagic) {
+		return FormatSQLite, nil
+	}
+	if isTDLibBinlog(content) {
+		return FormatTDLib, nil
+	}
+	return detectString(string(content))
+}
+
+// Session is a session read by OpenSession, with what its format stores about the account.
+type Session struct {
+	Format Format
+	Data   *session.Data
+	// UserID is 0 if the format doesn't store it, and OpenOptions.UserID isn't set.
+	UserID int64
+	// APIID is stored by Pyrogram sessions, it's TdesktopApiID for the other formats.
+	APIID int
+	Bot   bool
+}
+
+// Open reads the session from any of the supported formats.
+// Use OpenWithOptions for passcode-protected tdata folders, or tdata folders with several
+// accounts.
+func Open(source string) (*session.Data, error) {
+	return OpenWithOptions(source, OpenOptions{})
+}
+
+// OpenWithOptions reads the session from any of the supported formats, with the options needed
+// by some of them.
+func OpenWithOptions(source string, options OpenOptions) (*session.Data, error) {
+	s, err := OpenSession(source, options)
+	if err != nil {
+		return nil, err
+	}
+	return s.Data, nil
+}
+
+// OpenSession reads the session from any of the supported formats, with the user ID and API ID
+// of the account for the formats which store them.
+func OpenSession(source string, options OpenOptions) (*Session, error) {
+	format, err := Detect(source)
+	if err != nil {
+		return nil, err
+	}
+	if options.Format != "" && format != options.Format {
+		return nil, fmt.Errorf("%w: %s is a %s session, not %s", UnsupportedFormatError, source, format, options.Format)
+	}
+	s := &Session{Format: format, APIID: TdesktopApiID}
+	switch format {
+	case FormatSQLite:
+		sessionString, err := ConvertSQLiteSessionToTelethonStringSession(source)
+		if err != nil {
+			return nil, err
+		}
+		s.Data, err = ParseTelethonSession(sessionString)
+		if err != nil {
+			return nil, err
+		}
+	case FormatTDATA:
+		account, err := ReadTDATAAccount(source, options.Passcode, options.UserID)
+		if err != nil {
+			return nil, err
+		}
+		s.Data, s.UserID = account.Data, account.UserID
+	case FormatTDLib:
+		account, err := ReadTDLibBinlog(TDLibBinlogPath(source), options.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		s.Data, s.UserID = account.Data, account.UserID
+	default:
+		if _, err := os.Stat(source); err == nil {
+			content, err := os.ReadFile(source)
+			if err != nil {
+				return nil, err
+			}
+			source = string(content)
+		}
+		source = strings.TrimSpace(source)
+		if format == FormatPyrogram {
+			pyrogramSession, err := ParsePyrogramSession(source)
+			if err != nil {
+				return nil, err
+			}
+			s.Data, s.UserID, s.APIID, s.Bot = pyrogramSession.Data, pyrogramSession.UserID, pyrogramSession.APIID, pyrogramSession.IsBot
+			break
+		}
+		s.Data, err = parseString(source, format)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s.UserID == 0 {
+		s.UserID = options.UserID
+	}
+	return s, nil
+}
+
+// Encode encodes the session to one of the string formats: Telethon, GramJS or gotd.
+// Pyrogram needs the user ID, use EncodePyrogramSession or Session.Encode, and files are written
+// by WriteTelethonSQLiteSession and WriteTDATA.
+func Encode(sessionData *session.Data, to Format) (string, error) {
+	switch to {
+	case FormatTelethon:
+		return EncodeSessionToTelethonString(sessionData)
+	case FormatGramJS:
+		return EncodeSessionToGramJSString(sessionData)
+	case FormatGotd:
+		storage := &MemorySession{}
+		loader := session.Loader{Storage: storage}
+		if err := loader.Save(context.Background(), sessionData); err != nil {
+			return "", err
+		}
+		return string(storage.data), nil
+	default:
+		return "", fmt.Errorf("%w: can't encode to %q", UnsupportedFormatError, to)
+	}
+}
+
+// Encode encodes the session to one of the string formats, Pyrogram included.
+func (s *Session) Encode(to Format) (string, error) {
+	switch to {
+	case FormatPyrogram:
+		if s.UserID == 0 {
+			return "", s.userIDRequiredError()
+		}
+		return EncodePyrogramSession(s.Data, s.APIID, s.UserID, s.Bot)
+	case FormatSQLite, FormatTDATA:
+		return "", fmt.Errorf("%w: %q is written to a file, use Session.Write", UnsupportedFormatError, to)
+	default:
+		return Encode(s.Data, to)
+	}
+}
+
+// Write writes the session to the given path: a Telethon SQLite session, a tdata folder, or a
+// file containing the string session.
+func (s *Session) Write(to Format, path string) error {
+	switch to {
+	case FormatSQLite:
+		return WriteTelethonSQLiteSession(path, s.Data)
+	case FormatTDATA:
+		if s.UserID == 0 {
+			return s.userIDRequiredError()
+		}
+		return WriteTDATA(path, "", TDATASession{UserID: s.UserID, Data: s.Data})
+	}
+	sessionString, err := s.Encode(to)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(sessionString+"\n"), 0o600)
+}
+
+func (s *Session) userIDRequiredError() error {
+	if s.Format == "" {
+		return UserIDRequiredError
+	}
+	return fmt.Errorf("%w, and it isn't stored in %s sessions", UserIDRequiredError, s.Format)
+}
+
+// ConvertOptions are the options of ConvertWithOptions.
+type ConvertOptions struct {
+	OpenOptions
+	// Out is the file, or tdata folder, to write the converted session to. It's required by the
+	// sqlite and tdata formats.
+	Out string
+}
+
+// Convert reads the session from any of the supported formats, and encodes it to the given
+// string format.
+func Convert(source string, to Format) (string, error) {
+	return ConvertWithOptions(source, to, ConvertOptions{})
+}
+
+// ConvertWithOptions reads the session from any of the supported formats, and converts it to the
+// given format. The session is returned, unless it's written to options.Out.
+// OpenOptions.UserID is used as the user ID of the account when the source doesn't store it.
+func ConvertWithOptions(source string, to Format, options ConvertOptions) (string, error) {
+	s, err := OpenSession(source, options.OpenOptions)
+	if err != nil {
+		return "", err
+	}
+	if options.Out != "" {
+		return "", s.Write(to, options.Out)
+	}
+	return s.Encode(to)
+}
+
+func detectString(sessionString string) (Format, error) {
+	sessionString = strings.TrimSpace(sessionString)
+	switch {
+	case strings.HasPrefix(sessionString, "{"):
+		return FormatGotd, nil
+	case isPyrogramSessionString(sessionString):
+		return FormatPyrogram, nil
+	case isGramJSSessionString(sessionString):
+		return FormatGramJS, nil
+	case strings.HasPrefix(sessionString, string(latestTelethonVersion)):
+		return FormatTelethon, nil
+	default:
+		return "", UnknownFormatError
+	}
+}
+
+func parseString(sessionString string, format Format) (*session.Data, error) {
+	switch format {
+	case FormatTelethon:
+		return ParseTelethonSession(sessionString)
+	case FormatPyrogram:
+		pyrogramSession, err := ParsePyrogramSession(sessionString)
+		if err != nil {
+			return nil, err
+		}
+		return pyrogramSession.Data, nil
+	case FormatGramJS:
+		return ParseGramJSSession(sessionString)
+	case FormatGotd:
+		loader := session.Loader{Storage: &MemorySession{data: []byte(sessionString)}}
+		return loader.Load(context.Background())
+	default:
+		return nil, fmt.Errorf("%w: %q is not a string format", UnsupportedFormatError, format)
+	}
+}
+
+// isGramJSSessionString reports whether the string has the layout of a GramJS string session.
+// Both GramJS and Telethon sessions start with '1', but GramJS stores the length of the address,
+// which doesn't match when reading a Telethon session.
+func isGramJSSessionString(sessionString string) bool {
+	if len(sessionString) < 1 || sessionString[0] != latestGramJSVersion {
+		return false
+	}
+	data, err := base64.StdEncoding.DecodeString(sessionString[1:])
+	if err != nil {
+		data, err = base64.URLEncoding.DecodeString(sessionString[1:])
+		if err != nil {
+			return false
+		}
+	}
+	if len(data) < 3 {
+		return false
+	}
+	addrLength := int(binary.BigEndian.Uint16(data[1:3]))
+	if len(data) != 3+addrLength+2+256 {
+		return false
+	}
+	addr := string(data[3 : 3+addrLength])
+	return net.ParseIP(addr) != nil || isHostname(addr)
+}
+
+func isHostname(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func isTDATADirectory(dirname string) bool {
+	for _, suffix := range []string{"s", "0", "1"} {
+		if _, err := os.Stat(filepath.Join(dirname, "key_data"+suffix)); err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gotd/td/session"
+)
+
+func TestDetectAndOpen(t *testing.T) {
+	data := &session.Data{DC: 2, Addr: "149.154.167.51:443", AuthKey: testAuthKey()}
+	dir := t.TempDir()
+
+	telethon, err := EncodeSessionToTelethonString(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gramjs, err := EncodeSessionToGramJSString(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pyrogram, err := EncodePyrogramSession(data, 2040, 7513073974, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotd, err := Encode(data, FormatGotd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlitePath := filepath.Join(dir, "account.session")
+	if err := WriteTelethonSQLiteSession(sqlitePath, data); err != nil {
+		t.Fatal(err)
+	}
+	tdataPath := filepath.Join(dir, "tdata")
+	if err := WriteTDATA(tdataPath, "", TDATASession{UserID: 7513073974, Data: data}); err != nil {
+		t.Fatal(err)
+	}
+	gotdPath := filepath.Join(dir, "session.json")
+	if err := os.WriteFile(gotdPath, []byte(gotd), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := os.ReadFile("testdata/gramjs.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors []gramJSVector
+	if err := json.Unmarshal(raw, &vectors); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		source string
+		format Format
+	}{
+		{source: telethon, format: FormatTelethon},
+		{source: gramjs, format: FormatGramJS},
+		{source: vectors[2].Session, format: FormatGramJS},
+		{source: pyrogram, format: FormatPyrogram},
+		{source: gotd, format: FormatGotd},
+		{source: gotdPath, format: FormatGotd},
+		{source: sqlitePath, format: FormatSQLite},
+		{source: tdataPath, format: FormatTDATA},
+	} {
+		t.Run(string(test.format), func(t *testing.T) {
+			format, err := Detect(test.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != test.format {
+				t.Fatalf("detected %q instead of %q", format, test.format)
+			}
+			opened, err := Open(test.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opened.DC == 0 || len(opened.AuthKey) != 256 {
+				t.Fatalf("unexpected session: DC %d", opened.DC)
+			}
+		})
+	}
+
+	converted, err := Convert(gramjs, FormatTelethon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if converted != telethon {
+		t.Fatal("conversion from gramjs to telethon mismatch")
+	}
+	sessionData, err := ParseSessionString(gotd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sessionData.AuthKey, data.AuthKey) {
+		t.Fatal("auth key mismatch")
+	}
+	if _, err := Detect("not a session"); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+}
+
+func TestOpenTDATAWithOptions(t *testing.T) {
+	dirname := t.TempDir()
+	secondKey := testAuthKey()
+	secondKey[0] = 0xff
+	err := WriteTDATA(dirname, "passcode",
+		TDATASession{UserID: 7513073974, Data: &session.Data{DC: 2, AuthKey: testAuthKey()}},
+		TDATASession{UserID: 123456, Data: &session.Data{DC: 4, AuthKey: secondKey}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(dirname); err == nil {
+		t.Fatal("expected an error without the passcode")
+	}
+	_, err = OpenWithOptions(dirname, OpenOptions{Passcode: "passcode"})
+	if !errors.Is(err, MultipleAccountsError) || !strings.Contains(err.Error(), "7513073974, 123456") {
+		t.Fatalf("expected the accounts to be listed, got %v", err)
+	}
+	if _, err := OpenWithOptions(dirname, OpenOptions{Passcode: "passcode", UserID: 42}); err == nil {
+		t.Fatal("expected an error for an unknown account")
+	}
+	opened, err := OpenWithOptions(dirname, OpenOptions{Passcode: "passcode", UserID: 123456})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.DC != 4 || !bytes.Equal(opened.AuthKey, secondKey) {
+		t.Fatalf("unexpected session: DC %d", opened.DC)
+	}
+}
+
+func TestConvertWithOptions(t *testing.T) {
+	data := &session.Data{DC: 2, Addr: "149.154.167.51:443", AuthKey: testAuthKey()}
+	dir := t.TempDir()
+	pyrogram, err := EncodePyrogramSession(data, 2040, 7513073974, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	telethon, err := EncodeSessionToTelethonString(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The user ID and API ID stored by Pyrogram are kept in the tdata folder.
+	tdataPath := filepath.Join(dir, "tdata")
+	if _, err := ConvertWithOptions(pyrogram, FormatTDATA, ConvertOptions{Out: tdataPath}); err != nil {
+		t.Fatal(err)
+	}
+	converted, err := Convert(tdataPath, FormatPyrogram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := OpenSession(converted, OpenOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.UserID != 7513073974 || s.APIID != TdesktopApiID || !bytes.Equal(s.Data.AuthKey, data.AuthKey) {
+		t.Fatalf("unexpected session: %+v", s)
+	}
+
+	// Telethon sessions don't store the user ID.
+	if _, err := Convert(telethon, FormatPyrogram); !errors.Is(err, UserIDRequiredError) {
+		t.Fatalf("expected the user ID to be required, got %v", err)
+	}
+	converted, err = ConvertWithOptions(telethon, FormatPyrogram, ConvertOptions{OpenOptions: OpenOptions{UserID: 42}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pyrogramSession, err := ParsePyrogramSession(converted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pyrogramSession.UserID != 42 {
+		t.Fatalf("unexpected user ID: %d", pyrogramSession.UserID)
+	}
+
+	if _, err := ConvertWithOptions(telethon, FormatGramJS, ConvertOptions{OpenOptions: OpenOptions{Format: FormatPyrogram}}); !errors.Is(err, UnsupportedFormatError) {
+		t.Fatalf("expected a format mismatch, got %v", err)
+	}
+	if _, err := Convert(telethon, FormatSQLite); !errors.Is(err, UnsupportedFormatError) {
+		t.Fatalf("expected SQLite to require a file, got %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package session
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/gotd/td/crypto"
+	"github.com/gotd/td/session"
+)
+
+// https://github.com/gram-js/gramjs/blob/master/gramjs/sessions/StringSession.ts
+const latestGramJSVersion = '1'
+
+// ParseGramJSSession decodes a GramJS (and Telegram Web) StringSession.
+// Unlike Telethon, GramJS stores the server address as text, so it can be an IP or a hostname.
+func ParseGramJSSession(sessionString string) (*session.Data, error) {
+	if len(sessionString) < 1 {
+		return nil, fmt.Errorf("given string too small: %d", len(sessionString))
+	}
+	if sessionString[0] != latestGramJSVersion {
+		return nil, fmt.Errorf("unexpected version %q, latest supported is %q", sessionString[0], latestGramJSVersion)
+	}
+	// Node's Buffer accepts both base64 alphabets, so we do the same.
+	data, err := base64.StdEncoding.DecodeString(sessionString[1:])
+	if err != nil {
+		data, err = base64.URLEncoding.DecodeString(sessionString[1:])
+		if err != nil {
+			return nil, fmt.Errorf("couldn't decode gramjs session: %w", err)
+		}
+	}
+
+	// | 1    | byte   | DC ID          |
+	// | 2    | int16  | Address length |
+	// | n    | bytes  | Address        |
+	// | 2    | int16  | Port           |
+	// | 256  | bytes  | Auth key       |
+	if len(data) < 3 {
+		return nil, fmt.Errorf("gramjs session is too short: %d", len(data))
+	}
+	dc := int(data[0])
+	addrLength := int(binary.BigEndian.Uint16(data[1:3]))
+	if len(data) != 3+addrLength+2+256 {
+		return nil, fmt.Errorf("gramjs session has invalid length: %d", len(data))
+	}
+	addr := string(data[3 : 3+addrLength])
+	port := binary.BigEndian.Uint16(data[3+addrLength : 5+addrLength])
+
+	var key crypto.Key
+	copy(key[:], data[5+addrLength:])
+	id := key.WithID().ID
+
+	return &session.Data{
+		DC:        dc,
+		Addr:      net.JoinHostPort(addr, strconv.Itoa(int(port))),
+		AuthKey:   key[:],
+		AuthKeyID: id[:],
+	}, nil
+}
+
+// EncodeSessionToGramJSString encodes the session to a GramJS StringSession.
+// If the session doesn't have an address, the one from the built-in DC list is used.
+func EncodeSessionToGramJSString(sessionData *session.Data) (string, error) {
+	if len(sessionData.AuthKey) != 256 {
+		return "", fmt.Errorf("invalid auth key length: %d", len(sessionData.AuthKey))
+	}
+	addr := sessionData.Addr
+	if addr == "" {
+		var err error
+		addr, err = getDCAddress(sessionData.DC, sessionData.Config.TestMode)
+		if err != nil {
+			return "", err
+		}
+	}
+	host, portString, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid datacenter address %q: %w", addr, err)
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return "", fmt.Errorf("invalid datacenter port %q: %w", portString, err)
+	}
+
+	var buf bytes.Buffer
+	// | 1    | byte   | DC ID          |
+	buf.WriteByte(byte(sessionData.DC))
+	// | 2    | int16  | Address length |
+	buf.Write(binary.BigEndian.AppendUint16(nil, uint16(len(host))))
+	// | n    | bytes  | Address        |
+	buf.WriteString(host)
+	// | 2    | int16  | Port           |
+	buf.Write(binary.BigEndian.AppendUint16(nil, uint16(port)))
+	// | 256  | bytes  | Auth key       |
+	buf.Write(sessionData.AuthKey)
+
+	return string(latestGramJSVersion) + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
@@ -1,17 +1,23 @@
+// Package postgres keeps the Telegram accounts, their devices, sessions, updates state, peers and
+// proxies, in a postgres database or in an embedded SQLite one.
 package postgres
 
 import (
+	"context"
 	"embed"
+	"errors"
 	"fmt"
-	"log"
+	"io/fs"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 
 	"github.com/golang-migrate/migrate/v4"
-	_ "github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/golang-migrate/migrate/v4/database"
+	migratepostgres "github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/database/sqlite3"
+	"github.com/golang-migrate/migrate/v4/source"
 	"github.com/golang-migrate/migrate/v4/source/iofs"
 )
 
@@ -24,11 +30,11 @@ const (
 	MaxLifetimeConn = 0
 )
 
-//go:embed migrations/*.sql
-var fs embed.FS
+//go:embed migrations/*.sql migrations/sqlite/*.sql
+var migrations embed.FS
 
-// OpenDBConnection func for opening database connection.
-func OpenDBConnection(databaseURL string) (*sqlx.DB, error) {
+// Open opens the database connection, without migrating it, see Migrate.
+func Open(databaseURL string) (*sqlx.DB, error) {
 	db, err := sqlx.Connect("postgres", databaseURL)
 	if err != nil {
 		return nil, fmt.Errorf("error, not connected to database, %w", err)
@@ -44,24 +50,163 @@ func OpenDBConnection(databaseURL string) (*sqlx.DB, error) {
 		defer db.Close() // close database connection
 		return nil, fmt.Errorf("error, not sent ping to database, %w", err)
 	}
+	return db, nil
+}
 
-	// Run migrations scripts
-	d, err := iofs.New(fs, "migrations")
-	if err != nil {
-		log.Fatalln("Couldn't find migrations on disk.", err)
-	}
-	m, err := migrate.NewWithSourceInstance("iofs", d, databaseURL)
+// OpenDBConnection func for opening database connection, and migrating it to the latest
+// schema version.
+// Use Open to opt out of the migration.
+func OpenDBConnection(databaseURL string) (*sqlx.DB, error) {
+	db, err := Open(databaseURL)
 	if err != nil {
-		log.Fatalln("Couldn't start a new migrator.", err)
+		return nil, err
 	}
-	err = m.Up()
-	if err != nil && err.Error() != "no change" {
+	if err := Migrate(context.Background(), db, 0); err != nil {
+		db.Close()
 		return nil, err
 	}
-	_, _, err = m.Version()
+	return db, nil
+}
+
+// Migrate migrates the database up to the target schema version, or to the latest one when
+// the target is 0.
+// Both postgres and SQLite databases, opened by OpenSQLiteStore, are supported.
+func Migrate(ctx context.Context, db *sqlx.DB, targetVersion uint) error {
+	return runMigrations(ctx, db, func(m *migrate.Migrate) error {
+		if targetVersion == 0 {
+			return m.Up()
+		}
+		version, _, err := m.Version()
+		if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+			return err
+		}
+		if err == nil && version > targetVersion {
+			return fmt.Errorf("schema version %d is newer than %d, use MigrateDown", version, targetVersion)
+		}
+		return m.Migrate(targetVersion)
+	})
+}
+
+// MigrateDown reverts the migrations down to the target schema version, all of them when the
+// target is 0.
+func MigrateDown(ctx context.Context, db *sqlx.DB, targetVersion uint) error {
+	return runMigrations(ctx, db, func(m *migrate.Migrate) error {
+		if targetVersion == 0 {
+			return m.Down()
+		}
+		version, _, err := m.Version()
+		if err != nil {
+			return err
+		}
+		if version < targetVersion {
+			return fmt.Errorf("schema version %d is older than %d, use Migrate", version, targetVersion)
+		}
+		return m.Migrate(targetVersion)
+	})
+}
+
+// SchemaVersion returns the schema version of the database, 0 if it isn't migrated, and whether
+// the last migration failed half-way (dirty).
+func SchemaVersion(ctx context.Context, db *sqlx.DB) (version uint, dirty bool, err error) {
+	err = withMigrate(ctx, db, func(m *migrate.Migrate) error {
+		version, dirty, err = m.Version()
+		if errors.Is(err, migrate.ErrNilVersion) {
+			return nil
+		}
+		return err
+	})
+	return version, dirty, err
+}
+
+// LatestSchemaVersion returns the version of the last migration embedded in this package.
+func LatestSchemaVersion() (uint, error) {
+	src, err := iofs.New(migrations, "migrations")
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	version, err := src.First()
 	if err != nil {
-		log.Fatalln("Couldn't get database version.", err)
+		return 0, err
 	}
+	for {
+		next, err := src.Next(version)
+		if errors.Is(err, fs.ErrNotExist) {
+			return version, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		version = next
+	}
+}
 
-	return db, nil
+// runMigrations runs the migrations, stopping them after the current one if the context is
+// cancelled.
+func runMigrations(ctx context.Context, db *sqlx.DB, run func(m *migrate.Migrate) error) error {
+	return withMigrate(ctx, db, func(m *migrate.Migrate) error {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-ctx.Done():
+				m.GracefulStop <- true
+			case <-done:
+			}
+		}()
+		err := run(m)
+		if errors.Is(err, migrate.ErrNoChange) {
+			err = nil
+		}
+		if err == nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	})
+}
+
+// withMigrate creates a migrator on the database, with the migrations of its driver.
+// The migrator isn't closed, since it would close the database.
+func withMigrate(ctx context.Context, db *sqlx.DB, f func(m *migrate.Migrate) error) error {
+	var (
+		src    source.Driver
+		driver database.Driver
+		err    error
+	)
+	switch db.DriverName() {
+	case "postgres":
+		src, err = iofs.New(migrations, "migrations")
+		if err != nil {
+			return fmt.Errorf("couldn't find migrations: %w", err)
+		}
+		// A dedicated connection, released afterwards, since closing the driver closes the
+		// database.
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		driver, err = migratepostgres.WithConnection(ctx, conn, &migratepostgres.Config{})
+		if err != nil {
+			return fmt.Errorf("couldn't start a new migrator: %w", err)
+		}
+	case "sqlite3":
+		src, err = iofs.New(migrations, "migrations/sqlite")
+		if err != nil {
+			return fmt.Errorf("couldn't find migrations: %w", err)
+		}
+		driver, err = sqlite3.WithInstance(db.DB, &sqlite3.Config{})
+		if err != nil {
+			return fmt.Errorf("couldn't start a new migrator: %w", err)
+		}
+	default:
+		return fmt.Errorf("unsupported database driver %q", db.DriverName())
+	}
+	defer src.Close()
+
+	m, err := migrate.NewWithInstance("iofs", src, db.DriverName(), driver)
+	if err != nil {
+		return fmt.Errorf("couldn't start a new migrator: %w", err)
+	}
+	return f(m)
 }
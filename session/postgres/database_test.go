@@ -0,0 +1,56 @@
+package postgres
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	store, err := OpenSQLiteStore(filepath.Join(t.TempDir(), "accounts.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	latest, err := LatestSchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, dirty, err := SchemaVersion(ctx, store.DB())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != latest || dirty {
+		t.Fatalf("unexpected schema version %d (dirty: %v), expected %d", version, dirty, latest)
+	}
+
+	if err := MigrateDown(ctx, store.DB(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if version, _, err := SchemaVersion(ctx, store.DB()); err != nil || version != 1 {
+		t.Fatalf("unexpected schema version %d: %v", version, err)
+	}
+	if err := Migrate(ctx, store.DB(), 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(ctx, store.DB(), 1); err == nil {
+		t.Fatal("expected an error when migrating up to an older version")
+	}
+	if err := MigrateDown(ctx, store.DB(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if version, _, err := SchemaVersion(ctx, store.DB()); err != nil || version != 0 {
+		t.Fatalf("unexpected schema version %d: %v", version, err)
+	}
+	if err := Migrate(ctx, store.DB(), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := MigrateDown(cancelled, store.DB(), 0); err == nil {
+		t.Fatal("expected an error with a cancelled context")
+	}
+}
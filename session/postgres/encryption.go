@@ -0,0 +1,307 @@
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Encrypted values are stored as the prefix followed by the base64 encoded layout:
+// | 4  | bytes | Master key ID      |
+// | 12 | bytes | Data key nonce     |
+// | 48 | bytes | Encrypted data key |
+// | 12 | bytes | Nonce              |
+// | n  | bytes | Ciphertext         |
+// Every value has its own data key, encrypted by the master key, so rotating the master key
+// only re-encrypts the data keys.
+const (
+	encryptedValuePrefix = "enc1:"
+	masterKeySize        = 32
+	masterKeyIDSize      = 4
+	dataKeySize          = 32
+	gcmNonceSize         = 12
+	gcmTagSize           = 16
+)
+
+// MasterKeyEnv is the environment variable DefaultEncryption reads the master key from.
+const MasterKeyEnv = "STD_MASTER_KEY"
+
+var (
+	MasterKeyRequiredError = errors.New("value is encrypted, the master key is required")
+	UnknownMasterKeyError  = errors.New("value is encrypted with an unknown master key")
+)
+
+// Encryption encrypts the passwords and sessions stored by a Store with the master key, and
+// decrypts them with the master key or one of the old keys, which are used during rotation, see
+// Store.ReencryptRows.
+// Values stored in plain text are still read, until they are encrypted by Store.ReencryptRows.
+// A nil Encryption stores the values in plain text.
+// A Store uses the Encryption of WithEncryption, the functions taking a database, like
+// GetBotByUserIDContext, and ConnectToBotFromDatabase use DefaultEncryption.
+type Encryption struct {
+	// Master key used to encrypt.
+	key []byte
+	// Master keys used to decrypt, by ID, the current one included.
+	keys map[string][]byte
+}
+
+// NewEncryption creates the encryption of the master key, the old keys are only used to decrypt.
+func NewEncryption(key []byte, oldKeys ...[]byte) (*Encryption, error) {
+	keys := make(map[string][]byte, len(oldKeys)+1)
+	for _, k := range append(oldKeys, key) {
+		if len(k) != masterKeySize {
+			return nil, fmt.Errorf("invalid master key length: %d, expected %d", len(k), masterKeySize)
+		}
+		keys[string(masterKeyID(k))] = k
+	}
+	return &Encryption{key: key, keys: keys}, nil
+}
+
+// DefaultEncryption returns the encryption of the master key set in STD_MASTER_KEY, or nil if
+// it isn't set, so the values are stored in plain text.
+// The variable is read on every call, so it can be set after the program started.
+func DefaultEncryption() (*Encryption, error) {
+	if os.Getenv(MasterKeyEnv) == "" {
+		return nil, nil
+	}
+	key, err := MasterKeyFromEnv(MasterKeyEnv)
+	if err != nil {
+		return nil, err
+	}
+	return NewEncryption(key)
+}
+
+// MasterKeyFromEnv reads the master key from the environment variable, hex or base64 encoded.
+func MasterKeyFromEnv(env string) ([]byte, error) {
+	value, exists := os.LookupEnv(env)
+	if !exists {
+		return nil, fmt.Errorf("%s is not set", env)
+	}
+	return decodeMasterKey(value)
+}
+
+// MasterKeyFromFile reads the master key from the file, hex or base64 encoded.
+func MasterKeyFromFile(path string) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeMasterKey(string(content))
+}
+
+// GenerateMasterKey returns a new random master key, hex encoded.
+func GenerateMasterKey() (string, error) {
+	key := make([]byte, masterKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+func decodeMasterKey(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	if key, err := hex.DecodeString(value); err == nil && len(key) == masterKeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(value); err == nil && len(key) == masterKeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("master key must be %d bytes, hex or base64 encoded", masterKeySize)
+}
+
+func masterKeyID(key []byte) []byte {
+	hash := sha256.Sum256(key)
+	return hash[:masterKeyIDSize]
+}
+
+// encrypt encrypts the value with the master key, values are kept in plain text without
+// encryption.
+func (e *Encryption) encrypt(value string) (string, error) {
+	if e == nil || value == "" {
+		return value, nil
+	}
+	key := e.key
+
+	dataKey := make([]byte, dataKeySize)
+	dataKeyNonce := make([]byte, gcmNonceSize)
+	nonce := make([]byte, gcmNonceSize)
+	for _, b := range [][]byte{dataKey, dataKeyNonce, nonce} {
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+	}
+	masterAEAD, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	dataAEAD, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(masterKeyID(key))
+	buf.Write(dataKeyNonce)
+	buf.Write(masterAEAD.Seal(nil, dataKeyNonce, dataKey, nil))
+	buf.Write(nonce)
+	buf.Write(dataAEAD.Seal(nil, nonce, []byte(value), nil))
+	return encryptedValuePrefix + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// decrypt decrypts the value, values stored in plain text are returned as is.
+func (e *Encryption) decrypt(value string) (string, error) {
+	if !strings.HasPrefix(value, encryptedValuePrefix) {
+		return value, nil
+	}
+	content, err := base64.StdEncoding.DecodeString(value[len(encryptedValuePrefix):])
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted value: %w", err)
+	}
+	headerSize := masterKeyIDSize + gcmNonceSize + dataKeySize + gcmTagSize + gcmNonceSize
+	if len(content) < headerSize+gcmTagSize {
+		return "", errors.New("invalid encrypted value: truncated")
+	}
+
+	if e == nil {
+		return "", MasterKeyRequiredError
+	}
+	key, found := e.keys[string(content[:masterKeyIDSize])]
+	if !found {
+		return "", UnknownMasterKeyError
+	}
+	content = content[masterKeyIDSize:]
+
+	masterAEAD, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := masterAEAD.Open(nil, content[:gcmNonceSize], content[gcmNonceSize:gcmNonceSize+dataKeySize+gcmTagSize], nil)
+	if err != nil {
+		return "", fmt.Errorf("couldn't decrypt data key: %w", err)
+	}
+	content = content[gcmNonceSize+dataKeySize+gcmTagSize:]
+	dataAEAD, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := dataAEAD.Open(nil, content[:gcmNonceSize], content[gcmNonceSize:], nil)
+	if err != nil {
+		return "", fmt.Errorf("couldn't decrypt value: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// isEncryptedWithMasterKey reports whether the value is encrypted with the current master key.
+func (e *Encryption) isEncryptedWithMasterKey(value string) bool {
+	if e == nil || !strings.HasPrefix(value, encryptedValuePrefix) {
+		return false
+	}
+	content, err := base64.StdEncoding.DecodeString(value[len(encryptedValuePrefix):])
+	if err != nil || len(content) < masterKeyIDSize {
+		return false
+	}
+	return bytes.Equal(content[:masterKeyIDSize], masterKeyID(e.key))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sessionHash identifies the device, since its encrypted session string changes every time
+// it's stored.
+func sessionHash(sessionString string) string {
+	hash := sha256.Sum256([]byte(sessionString))
+	return hex.EncodeToString(hash[:])
+}
+
+// ReencryptRows encrypts the passwords and sessions stored in plain text, or with one of the
+// old master keys, with the current master key of the store, see WithEncryption.
+// It returns the number of updated rows.
+func (s *Store) ReencryptRows(ctx context.Context) (int, error) {
+	e := s.encryption
+	if e == nil {
+		return 0, errors.New("master key is not set")
+	}
+
+	updated := 0
+	err := WithTx(ctx, s.db, func(tx *sqlx.Tx) error {
+		var bots []Bot
+		if err := tx.SelectContext(ctx, &bots, "SELECT * FROM bots"); err != nil {
+			return err
+		}
+		for _, bot := range bots {
+			if bot.Password == "" || e.isEncryptedWithMasterKey(bot.Password) {
+				continue
+			}
+			password, err := e.reencrypt(bot.Password)
+			if err != nil {
+				return fmt.Errorf("bot %d: %w", bot.UserID, err)
+			}
+			if _, err := tx.ExecContext(ctx, "UPDATE bots SET password=$1 WHERE user_id=$2", password, bot.UserID); err != nil {
+				return err
+			}
+			updated++
+		}
+
+		var devices []Device
+		if err := tx.SelectContext(ctx, &devices, "SELECT * FROM devices"); err != nil {
+			return err
+		}
+		for _, device := range devices {
+			if e.isEncryptedWithMasterKey(device.SessionString) && device.SessionHash.Valid &&
+				(!device.SessionData.Valid || e.isEncryptedWithMasterKey(device.SessionData.String)) {
+				continue
+			}
+			plainSessionString, err := e.decrypt(device.SessionString)
+			if err != nil {
+				return fmt.Errorf("device of bot %d: %w", device.BotUserID, err)
+			}
+			sessionString, err := e.encrypt(plainSessionString)
+			if err != nil {
+				return err
+			}
+			sessionData := device.SessionData
+			if sessionData.Valid {
+				if sessionData.String, err = e.reencrypt(sessionData.String); err != nil {
+					return fmt.Errorf("device of bot %d: %w", device.BotUserID, err)
+				}
+			}
+			_, err = tx.ExecContext(ctx,
+				"UPDATE devices SET session_string=$1, session_data=$2, session_hash=$3 WHERE session_string=$4",
+				sessionString, sessionData, sessionHash(plainSessionString), device.SessionString,
+			)
+			if err != nil {
+				return err
+			}
+			updated++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return updated, nil
+}
+
+func (e *Encryption) reencrypt(value string) (string, error) {
+	plaintext, err := e.decrypt(value)
+	if err != nil {
+		return "", err
+	}
+	return e.encrypt(plaintext)
+}
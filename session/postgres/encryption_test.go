@@ -0,0 +1,202 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prdsrm/std/session"
+)
+
+func TestReencryptRows(t *testing.T) {
+	ctx := context.Background()
+	store, err := OpenSQLiteStore(filepath.Join(t.TempDir(), "accounts.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// Rows stored in plain text, before encryption is enabled.
+	sessionString, err := session.EncodeSessionToTelethonString(testSessionData(2, "149.154.167.50:443"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bot := &Bot{UserID: 1, PhoneNumber: "33600000000", Password: "2fa password", Title: "A"}
+	if err := store.InsertBotWithDevice(ctx, bot, &Device{SessionString: sessionString}); err != nil {
+		t.Fatal(err)
+	}
+
+	rawValues := func() (string, string) {
+		var password, sessionString string
+		if err := store.DB().Get(&password, "SELECT password FROM bots"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.DB().Get(&sessionString, "SELECT session_string FROM devices"); err != nil {
+			t.Fatal(err)
+		}
+		return password, sessionString
+	}
+	// The stores share the database, with different master keys.
+	withKey := func(key []byte, oldKeys ...[]byte) *Store {
+		encryption, err := NewEncryption(key, oldKeys...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return NewStore(store.DB(), WithEncryption(encryption))
+	}
+	checkDecrypted := func(store *Store) {
+		got, err := store.GetBot(ctx, bot.UserID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Password != bot.Password {
+			t.Fatalf("unexpected password: %q", got.Password)
+		}
+		device, err := store.GetRandomDevice(ctx, bot.UserID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if device.SessionString != sessionString {
+			t.Fatalf("unexpected session string: %q", device.SessionString)
+		}
+	}
+
+	if _, err := store.ReencryptRows(ctx); err == nil {
+		t.Fatal("expected an error without master key")
+	}
+	oldKey := make([]byte, masterKeySize)
+	oldStore := withKey(oldKey)
+	if updated, err := oldStore.ReencryptRows(ctx); err != nil || updated != 2 {
+		t.Fatalf("unexpected result: %d rows, %v", updated, err)
+	}
+	password, encryptedSessionString := rawValues()
+	if !strings.HasPrefix(password, encryptedValuePrefix) || !strings.HasPrefix(encryptedSessionString, encryptedValuePrefix) {
+		t.Fatalf("rows weren't encrypted: %q, %q", password, encryptedSessionString)
+	}
+	checkDecrypted(oldStore)
+
+	// Rotation.
+	newKey, err := GenerateMasterKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := decodeMasterKey(newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated, err := withKey(key, oldKey).ReencryptRows(ctx); err != nil || updated != 2 {
+		t.Fatalf("unexpected result: %d rows, %v", updated, err)
+	}
+	newStore := withKey(key)
+	checkDecrypted(newStore)
+	accounts, err := newStore.ExportAccounts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 1 || accounts[0].Bot.Password != bot.Password || accounts[0].Devices[0].SessionString != sessionString {
+		t.Fatalf("unexpected export: %+v", accounts)
+	}
+	encryption, err := NewEncryption(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, err := OpenSQLiteStore(filepath.Join(t.TempDir(), "imported.db"), WithEncryption(encryption))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer imported.Close()
+	if err := imported.ImportAccounts(ctx, accounts); err != nil {
+		t.Fatal(err)
+	}
+	checkDecrypted(imported)
+	if err := newStore.DeleteDevice(ctx, sessionString); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newStore.GetRandomDevice(ctx, bot.UserID); err == nil {
+		t.Fatal("device wasn't deleted")
+	}
+
+	if _, err := oldStore.GetBot(ctx, bot.UserID); !errors.Is(err, UnknownMasterKeyError) {
+		t.Fatalf("expected UnknownMasterKeyError, got %v", err)
+	}
+	if _, err := store.GetBot(ctx, bot.UserID); !errors.Is(err, MasterKeyRequiredError) {
+		t.Fatalf("expected MasterKeyRequiredError, got %v", err)
+	}
+}
+
+func TestDefaultEncryption(t *testing.T) {
+	ctx := context.Background()
+	store, err := OpenSQLiteStore(filepath.Join(t.TempDir(), "accounts.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	db := store.DB()
+
+	key, err := GenerateMasterKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(MasterKeyEnv, key)
+	sessionString, err := session.EncodeSessionToTelethonString(testSessionData(2, "149.154.167.50:443"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := InsertNewBot(db, "33600000000", "", "2fa password", 1, "A"); err != nil {
+		t.Fatal(err)
+	}
+	if err := InsertNewDevice(db, 1, session.TdesktopApiID, session.TdesktopApiHash, sessionString, "", "", "", "", "", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	var password string
+	if err := db.Get(&password, "SELECT password FROM bots"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(password, encryptedValuePrefix) {
+		t.Fatalf("the password wasn't encrypted: %q", password)
+	}
+
+	// The functions taking a database decrypt the rows with the master key of the environment.
+	bot, err := GetBotByUserID(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bot.Password != "2fa password" {
+		t.Fatalf("unexpected password: %q", bot.Password)
+	}
+	device, err := GetRandomDevice(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device.SessionString != sessionString {
+		t.Fatalf("unexpected session string: %q", device.SessionString)
+	}
+	gotdSession, err := session.Encode(testSessionData(2, "149.154.167.50:443"), session.FormatGotd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewSessionStorage(db, device).StoreSession(ctx, []byte(gotdSession)); err != nil {
+		t.Fatal(err)
+	}
+	var sessionData string
+	if err := db.Get(&sessionData, "SELECT session_data FROM devices"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sessionData, encryptedValuePrefix) {
+		t.Fatal("the gotd session wasn't encrypted")
+	}
+	if loaded, err := NewSessionStorage(db, device).LoadSession(ctx); err != nil || string(loaded) != gotdSession {
+		t.Fatalf("unexpected session: %v", err)
+	}
+
+	t.Setenv(MasterKeyEnv, "")
+	if _, err := GetBotByUserID(db, 1); !errors.Is(err, MasterKeyRequiredError) {
+		t.Fatalf("expected the master key to be required, got %v", err)
+	}
+	t.Setenv(MasterKeyEnv, "invalid")
+	if _, err := GetBotByUserID(db, 1); err == nil {
+		t.Fatal("expected an error for an invalid master key")
+	}
+}
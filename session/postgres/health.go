@@ -0,0 +1,127 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/telegram/auth"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+	"github.com/jmoiron/sqlx"
+	"github.com/prdsrm/std/session"
+)
+
+// Query of the bots which can be connected to: authorized, and not waiting for a FLOOD_WAIT.
+const healthyBotsCondition = `NOT unauthorized AND (flood_wait_until IS NULL OR flood_wait_until < $1)`
+
+// RecordBotConnection records the result of a connection to the bot, nil if it succeeded.
+// Only Telegram errors, and failed logins of sessions which aren't authorized, are recorded,
+// network errors don't say anything about the account.
+func RecordBotConnection(db *sqlx.DB, botUserID int64, connectErr error) error {
+	return RecordBotConnectionContext(context.Background(), db, botUserID, connectErr)
+}
+
+func RecordBotConnectionContext(ctx context.Context, db sqlx.ExtContext, botUserID int64, connectErr error) error {
+	now := healthNow()
+	if connectErr == nil {
+		_, err := db.ExecContext(ctx,
+			"UPDATE bots SET last_connected_at=$1, last_error_code='', unauthorized=false WHERE user_id=$2",
+			now, botUserID,
+		)
+		return err
+	}
+	if d, ok := tgerr.AsFloodWait(connectErr); ok {
+		return RecordBotFloodWaitContext(ctx, db, botUserID, now.Add(d))
+	}
+	unauthorized := isUnauthorizedError(connectErr)
+	code := notAuthorizedErrorCode
+	if rpcErr, ok := tgerr.As(connectErr); ok {
+		code = rpcErr.Type
+	} else if !unauthorized {
+		return nil
+	}
+	_, err := db.ExecContext(ctx,
+		"UPDATE bots SET last_error_code=$1, unauthorized=(unauthorized OR $2) WHERE user_id=$3",
+		code, unauthorized, botUserID,
+	)
+	return err
+}
+
+// RecordBotFloodWait records that the bot can't send requests until the given time.
+func RecordBotFloodWait(db *sqlx.DB, botUserID int64, until time.Time) error {
+	return RecordBotFloodWaitContext(context.Background(), db, botUserID, until)
+}
+
+func RecordBotFloodWaitContext(ctx context.Context, db sqlx.ExtContext, botUserID int64, until time.Time) error {
+	_, err := db.ExecContext(ctx,
+		"UPDATE bots SET last_error_code=$1, flood_wait_until=$2 WHERE user_id=$3",
+		tgerr.ErrFloodWait, until.UTC().Truncate(time.Second), botUserID,
+	)
+	return err
+}
+
+// GetHealthyBots returns the bots which are authorized, and not waiting for a FLOOD_WAIT.
+func GetHealthyBots(db *sqlx.DB) ([]Bot, error) {
+	return GetHealthyBotsContext(context.Background(), db)
+}
+
+func GetHealthyBotsContext(ctx context.Context, db sqlx.ExtContext) ([]Bot, error) {
+	e, err := DefaultEncryption()
+	if err != nil {
+		return nil, err
+	}
+	return getHealthyBots(ctx, db, e)
+}
+
+func getHealthyBots(ctx context.Context, db sqlx.ExtContext, e *Encryption) ([]Bot, error) {
+	var bots []Bot
+	err := sqlx.SelectContext(ctx, db, &bots, "SELECT * FROM bots WHERE "+healthyBotsCondition, healthNow())
+	if err != nil {
+		return nil, err
+	}
+	for i := range bots {
+		if err := bots[i].decrypt(e); err != nil {
+			return nil, err
+		}
+	}
+	return bots, nil
+}
+
+// Error code recorded when the session isn't authorized anymore, and logging in failed without a
+// Telegram error, like when the login code is required.
+const notAuthorizedErrorCode = "NOT_AUTHORIZED"
+
+// isUnauthorizedError reports whether the account can't be used anymore, until it's logged in
+// again: logged out, banned, deleted, or not authorized after loading the session. Logins stopped
+// by the network or the context don't count.
+func isUnauthorizedError(err error) bool {
+	if auth.IsUnauthorized(err) || tgerr.Is(err, "PHONE_NUMBER_BANNED", "AUTH_KEY_DUPLICATED") {
+		return true
+	}
+	return errors.Is(err, session.NotAuthorizedError) && !isNetworkError(err) &&
+		!errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// floodWaitRecorder records the FLOOD_WAIT errors of the bot, before they are waited.
+func floodWaitRecorder(store AccountStore, botUserID int64) telegram.Middleware {
+	return telegram.MiddlewareFunc(func(next tg.Invoker) telegram.InvokeFunc {
+		return func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+			err := next.Invoke(ctx, input, output)
+			if d, ok := tgerr.AsFloodWait(err); ok {
+				if recordErr := store.RecordBotFloodWait(ctx, botUserID, healthNow().Add(d)); recordErr != nil {
+					return errors.Join(err, recordErr)
+				}
+			}
+			return err
+		}
+	})
+}
+
+// healthNow returns the current time, in UTC and truncated to the second, since SQLite compares
+// times as strings.
+func healthNow() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
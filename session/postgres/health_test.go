@@ -0,0 +1,147 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+	"github.com/gotd/td/tgtest"
+	"github.com/gotd/td/tgtest/cluster"
+
+	"github.com/prdsrm/std/session"
+)
+
+func TestBotsHealth(t *testing.T) {
+	ctx := context.Background()
+	store, err := OpenSQLiteStore(filepath.Join(t.TempDir(), "accounts.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for _, id := range []int64{1, 2, 3} {
+		if err := store.InsertBot(ctx, &Bot{UserID: id, PhoneNumber: fmt.Sprintf("3360000000%d", id)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, record := range []struct {
+		id  int64
+		err error
+	}{
+		{id: 1, err: nil},
+		{id: 1, err: errors.New("connection refused")},
+		// Logging in was stopped, it doesn't say the account is unauthorized.
+		{id: 1, err: fmt.Errorf("%w: %w", session.NotAuthorizedError, context.Canceled)},
+		{id: 2, err: tgerr.New(420, "FLOOD_WAIT_60")},
+		{id: 3, err: tgerr.New(401, "AUTH_KEY_UNREGISTERED")},
+		{id: 3, err: tgerr.New(400, "CHANNEL_PRIVATE")},
+	} {
+		if err := store.RecordBotConnection(ctx, record.id, record.err); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bots, err := store.GetHealthyBots(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bots) != 1 || bots[0].UserID != 1 || !bots[0].LastConnectedAt.Valid || bots[0].LastErrorCode != "" {
+		t.Fatalf("unexpected healthy bots: %+v", bots)
+	}
+	if bot, err := store.GetRandomBot(ctx); err != nil || bot.UserID != 1 {
+		t.Fatalf("unexpected random bot: %+v, %v", bot, err)
+	}
+	flooded, err := store.GetBot(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flooded.LastErrorCode != tgerr.ErrFloodWait || !flooded.FloodWaitUntil.Time.After(time.Now()) {
+		t.Fatalf("unexpected flood wait: %q until %v", flooded.LastErrorCode, flooded.FloodWaitUntil.Time)
+	}
+	unauthorized, err := store.GetBot(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The account stays unauthorized until it's connected again.
+	if !unauthorized.Unauthorized || unauthorized.LastErrorCode != "CHANNEL_PRIVATE" {
+		t.Fatalf("unexpected health: unauthorized %v, %q", unauthorized.Unauthorized, unauthorized.LastErrorCode)
+	}
+
+	// FLOOD_WAIT is over.
+	if err := store.RecordBotFloodWait(ctx, 2, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RecordBotConnection(ctx, 3, nil); err != nil {
+		t.Fatal(err)
+	}
+	bots, err = store.GetHealthyBots(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bots) != 3 {
+		t.Fatalf("expected 3 healthy bots, got %d", len(bots))
+	}
+}
+
+func TestConnectToBotUnauthorized(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	// The test server doesn't know the session, which is logged out, and the login code can't
+	// be provided.
+	c := cluster.NewCluster(cluster.Options{})
+	c.Dispatch(2, "dc2").
+		HandleFunc(tg.UsersGetUsersRequestTypeID, func(server *tgtest.Server, req *tgtest.Request) error {
+			return server.SendErr(req, tgerr.New(401, "AUTH_KEY_UNREGISTERED"))
+		}).
+		Result(tg.AuthSendCodeRequestTypeID, &tg.AuthSentCode{
+			Type:          &tg.AuthSentCodeTypeApp{Length: 5},
+			PhoneCodeHash: "hash",
+		})
+	go c.Up(ctx)
+	select {
+	case <-c.Ready():
+	case <-ctx.Done():
+		t.Fatal(ctx.Err())
+	}
+	store, err := OpenSQLiteStore(filepath.Join(t.TempDir(), "accounts.db"), WithConnectionOptions(func(opts *session.ConnectOptions) {
+		opts.DCList = c.List()
+		opts.PublicKeys = c.Keys()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	sessionString, err := session.EncodeSessionToTelethonString(testSessionData(2, "127.0.0.1:443"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bot := &Bot{UserID: 1, PhoneNumber: "33600000000"}
+	device := &Device{ApiID: 2040, ApiHash: "b18441a1ff607e10a989891a5462e627", SessionString: sessionString}
+	if err := store.InsertBotWithDevice(ctx, bot, device); err != nil {
+		t.Fatal(err)
+	}
+	err = ConnectToBotContext(ctx, store, bot, func(ctx context.Context, client *telegram.Client, dispatcher tg.UpdateDispatcher, options telegram.Options) error {
+		t.Error("connected without being authorized")
+		return nil
+	})
+	if !errors.Is(err, session.NotAuthorizedError) || !errors.Is(err, session.CodeRequiredError) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	unauthorized, err := store.GetBot(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !unauthorized.Unauthorized || unauthorized.LastErrorCode != notAuthorizedErrorCode {
+		t.Fatalf("unexpected health: unauthorized %v, %q", unauthorized.Unauthorized, unauthorized.LastErrorCode)
+	}
+	if bots, err := store.GetHealthyBots(ctx); err != nil || len(bots) != 0 {
+		t.Fatalf("unexpected healthy bots: %+v, %v", bots, err)
+	}
+}
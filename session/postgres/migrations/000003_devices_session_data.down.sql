@@ -0,0 +1,5 @@
+ALTER TABLE devices
+    DROP COLUMN session_data;
+
+-- session_string keeps its wider type: shrinking it back to 355 characters would fail on the
+-- longer sessions, like the Telethon string sessions with an IPv6 address.
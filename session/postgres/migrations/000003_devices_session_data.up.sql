@@ -0,0 +1,8 @@
+-- gotd session, kept next to the Telethon string session, both are updated when gotd
+-- migrates to another DC or creates a new auth key.
+ALTER TABLE devices
+    ADD COLUMN session_data text;
+
+-- Telethon string sessions with an IPv6 address are longer.
+ALTER TABLE devices
+    ALTER COLUMN session_string TYPE character varying(512);
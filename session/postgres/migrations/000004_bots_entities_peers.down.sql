@@ -0,0 +1,7 @@
+DROP INDEX entities_resolve_key;
+
+ALTER TABLE bots_entities
+    DROP COLUMN peer;
+
+ALTER TABLE bots_entities
+    DROP COLUMN resolve_key;
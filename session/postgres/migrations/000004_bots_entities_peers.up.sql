@@ -0,0 +1,10 @@
+-- Peers seen by the bots, so usernames are resolved from the database instead of Telegram.
+-- entity_id_2 is the peer ID marked like TDLib does: positive for users, negative for chats,
+-- and -100... for channels.
+ALTER TABLE bots_entities
+    ADD COLUMN resolve_key character varying(64);
+
+ALTER TABLE bots_entities
+    ADD COLUMN peer text;
+
+CREATE INDEX entities_resolve_key ON bots_entities (entity_id_1, resolve_key);
@@ -0,0 +1,7 @@
+DROP INDEX device_session_hash;
+
+ALTER TABLE devices
+    DROP COLUMN session_hash;
+
+-- password and session_string keep their wider type: the encrypted values don't fit in the
+-- previous ones, and they can only be decrypted with the master key, see Store.ReencryptRows.
@@ -0,0 +1,15 @@
+-- Encrypted passwords and sessions are longer than the plain text ones.
+ALTER TABLE bots
+    ALTER COLUMN password TYPE text;
+
+ALTER TABLE devices
+    ALTER COLUMN session_string TYPE text;
+
+-- SHA-256 of the plain text session string, which identifies the device once its session
+-- string is encrypted.
+ALTER TABLE devices
+    ADD COLUMN session_hash character varying(64);
+
+UPDATE devices SET session_hash = encode(sha256(session_string::bytea), 'hex');
+
+CREATE UNIQUE INDEX device_session_hash ON devices (session_hash);
@@ -0,0 +1,11 @@
+ALTER TABLE bots
+    DROP COLUMN unauthorized;
+
+ALTER TABLE bots
+    DROP COLUMN flood_wait_until;
+
+ALTER TABLE bots
+    DROP COLUMN last_error_code;
+
+ALTER TABLE bots
+    DROP COLUMN last_connected_at;
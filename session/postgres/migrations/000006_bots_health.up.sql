@@ -0,0 +1,14 @@
+-- Health of the accounts, updated on every connection.
+ALTER TABLE bots
+    ADD COLUMN last_connected_at timestamp with time zone;
+
+-- Telegram error code of the last failed connection, or RPC call.
+ALTER TABLE bots
+    ADD COLUMN last_error_code character varying(64) DEFAULT '' NOT NULL;
+
+ALTER TABLE bots
+    ADD COLUMN flood_wait_until timestamp with time zone;
+
+-- The account was logged out, banned or deleted, it needs to be logged in again.
+ALTER TABLE bots
+    ADD COLUMN unauthorized boolean DEFAULT false NOT NULL;
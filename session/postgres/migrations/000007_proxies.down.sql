@@ -0,0 +1,6 @@
+ALTER TABLE devices
+    DROP COLUMN last_proxy;
+
+DROP TABLE bots_proxies;
+
+DROP TABLE proxies;
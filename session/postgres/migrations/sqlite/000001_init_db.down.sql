@@ -0,0 +1,3 @@
+DROP TABLE devices;
+DROP TABLE bots_entities;
+DROP TABLE bots;
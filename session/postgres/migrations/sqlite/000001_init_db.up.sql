@@ -0,0 +1,35 @@
+-- Database initialization, same schema as the postgres one.
+CREATE TABLE bots (
+    phone_number character varying(15) NOT NULL,
+    user_id bigint NOT NULL,
+    username character varying(32) DEFAULT '' NOT NULL,
+    password character varying(64) DEFAULT '' NOT NULL,
+    title character varying(64) DEFAULT 'A' NOT NULL,
+    premium boolean DEFAULT false,
+    CONSTRAINT bots_pkey PRIMARY KEY (user_id)
+);
+
+CREATE TABLE bots_entities (
+    entity_id_1 bigint NOT NULL,
+    entity_id_2 bigint NOT NULL,
+    hash bigint,
+    CONSTRAINT entities_ids_uniques UNIQUE (entity_id_1, entity_id_2),
+    CONSTRAINT entities_fk_1 FOREIGN KEY (entity_id_1) REFERENCES bots(user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE devices (
+    bot_user_id bigint NOT NULL,
+    api_id integer DEFAULT 2040 NOT NULL,
+    api_hash character varying(32) DEFAULT 'b18441a1ff607e10a989891a5462e627' NOT NULL,
+    session_string character varying(355) NOT NULL,
+    device_model character varying(512) DEFAULT 'XPS 13 9370' NOT NULL,
+    system_version character varying(32) DEFAULT 'Windows 10' NOT NULL,
+    app_version character varying(32) DEFAULT '5.0.1 x64' NOT NULL,
+    lang_pack character varying(16) DEFAULT 'tdesktop' NOT NULL,
+    lang_code character varying(16) DEFAULT 'en' NOT NULL,
+    system_lang_code character varying(16) DEFAULT 'en-US' NOT NULL,
+    proxy character varying(256) DEFAULT '' NOT NULL,
+    creation_date date DEFAULT CURRENT_DATE NOT NULL,
+    CONSTRAINT device_unique UNIQUE (session_string),
+    CONSTRAINT device_fk_1 FOREIGN KEY (bot_user_id) REFERENCES bots(user_id) ON DELETE CASCADE
+);
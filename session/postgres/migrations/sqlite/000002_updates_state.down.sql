@@ -0,0 +1,2 @@
+DROP TABLE channels_pts;
+DROP TABLE states;
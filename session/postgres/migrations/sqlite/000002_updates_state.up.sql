@@ -0,0 +1,17 @@
+CREATE TABLE states (
+    bot_user_id bigint NOT NULL,
+    pts integer NOT NULL,
+    qts integer NOT NULL,
+    date integer NOT NULL,
+    seq integer NOT NULL,
+    CONSTRAINT states_pkey PRIMARY KEY (bot_user_id),
+    CONSTRAINT states_fk_1 FOREIGN KEY (bot_user_id) REFERENCES bots(user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE channels_pts (
+    bot_user_id bigint NOT NULL,
+    channel_id bigint NOT NULL,
+    pts integer NOT NULL,
+    CONSTRAINT channels_pts_pkey PRIMARY KEY (bot_user_id, channel_id),
+    CONSTRAINT channels_pts_fk_1 FOREIGN KEY (bot_user_id) REFERENCES bots(user_id) ON DELETE CASCADE
+);
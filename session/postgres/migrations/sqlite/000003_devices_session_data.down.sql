@@ -0,0 +1,2 @@
+ALTER TABLE devices
+    DROP COLUMN session_data;
@@ -0,0 +1,3 @@
+-- SQLite doesn't enforce the length of session_string, only the column is added.
+ALTER TABLE devices
+    ADD COLUMN session_data text;
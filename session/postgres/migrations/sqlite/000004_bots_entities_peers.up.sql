@@ -0,0 +1,7 @@
+ALTER TABLE bots_entities
+    ADD COLUMN resolve_key character varying(64);
+
+ALTER TABLE bots_entities
+    ADD COLUMN peer text;
+
+CREATE INDEX entities_resolve_key ON bots_entities (entity_id_1, resolve_key);
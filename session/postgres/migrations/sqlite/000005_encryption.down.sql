@@ -0,0 +1,4 @@
+DROP INDEX device_session_hash;
+
+ALTER TABLE devices
+    DROP COLUMN session_hash;
@@ -0,0 +1,6 @@
+-- SHA-256 of the plain text session string, which identifies the device once its session
+-- string is encrypted. SQLite can't compute it, it's set by `Store.ReencryptRows`.
+ALTER TABLE devices
+    ADD COLUMN session_hash character varying(64);
+
+CREATE UNIQUE INDEX device_session_hash ON devices (session_hash);
@@ -0,0 +1,24 @@
+-- Pool of proxies, probed by CheckProxies.
+CREATE TABLE proxies (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    url text NOT NULL,
+    healthy boolean DEFAULT true NOT NULL,
+    latency_ms integer,
+    last_checked_at timestamp,
+    last_error text DEFAULT '' NOT NULL,
+    CONSTRAINT proxies_url_key UNIQUE (url)
+);
+
+-- Failover list of the proxies of each bot, tried by ascending priority.
+CREATE TABLE bots_proxies (
+    bot_user_id bigint NOT NULL,
+    proxy_id bigint NOT NULL,
+    priority integer DEFAULT 0 NOT NULL,
+    CONSTRAINT bots_proxies_pkey PRIMARY KEY (bot_user_id, proxy_id),
+    CONSTRAINT bots_proxies_fk_1 FOREIGN KEY (bot_user_id) REFERENCES bots(user_id) ON DELETE CASCADE,
+    CONSTRAINT bots_proxies_fk_2 FOREIGN KEY (proxy_id) REFERENCES proxies(id) ON DELETE CASCADE
+);
+
+-- Proxy used by the last successful connection of the device, NULL if it connected directly.
+ALTER TABLE devices
+    ADD COLUMN last_proxy text;
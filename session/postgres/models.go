@@ -2,11 +2,13 @@ package postgres
 
 import (
 	"database/sql"
+	"fmt"
 )
 
 type ChannelsPts struct {
-	ChannelID int64
-	Pts       int
+	BotUserID int64 `db:"bot_user_id"`
+	ChannelID int64 `db:"channel_id"`
+	Pts       int   `db:"pts"`
 }
 
 type State struct {
@@ -19,18 +21,25 @@ type State struct {
 }
 
 type BotAndEntityRelationship struct {
-	FirstEntityID  int64         `db:"entity_id_1"`
-	SecondEntityID int64         `db:"entity_id_2"`
-	Hash           sql.NullInt64 `db:"hash"`
+	FirstEntityID  int64          `db:"entity_id_1"`
+	SecondEntityID int64          `db:"entity_id_2"`
+	Hash           sql.NullInt64  `db:"hash"`
+	ResolveKey     sql.NullString `db:"resolve_key"`
+	Peer           sql.NullString `db:"peer"`
 }
 
 type Bot struct {
-  UserID      int64  `db:"user_id" json:"user_id"`
-  PhoneNumber string `db:"phone_number" json:"phone_number"`
-  Username    string `db:"username" json:"username"`
-  Password    string `db:"password" json:"password"`
-  Title       string `db:"title" json:"title"`
-  Premium     bool   `db:"premium" json:"premium"`
+	UserID      int64  `db:"user_id" json:"user_id"`
+	PhoneNumber string `db:"phone_number" json:"phone_number"`
+	Username    string `db:"username" json:"username"`
+	Password    string `db:"password" json:"password"`
+	Title       string `db:"title" json:"title"`
+	Premium     bool   `db:"premium" json:"premium"`
+	// Health of the account, see RecordBotConnection.
+	LastConnectedAt sql.NullTime `db:"last_connected_at" json:"last_connected_at"`
+	LastErrorCode   string       `db:"last_error_code" json:"last_error_code"`
+	FloodWaitUntil  sql.NullTime `db:"flood_wait_until" json:"flood_wait_until"`
+	Unauthorized    bool         `db:"unauthorized" json:"unauthorized"`
 }
 
 type Device struct {
@@ -38,6 +47,8 @@ type Device struct {
 	ApiID          int            `db:"api_id" json:"api_id"`
 	ApiHash        string         `db:"api_hash" json:"api_hash"`
 	SessionString  string         `db:"session_string" json:"session_string"`
+	SessionData    sql.NullString `db:"session_data" json:"session_data"`
+	SessionHash    sql.NullString `db:"session_hash" json:"session_hash"`
 	DeviceModel    string         `db:"device_model" json:"device_model"`
 	SystemVersion  string         `db:"system_version" json:"system_version"`
 	AppVersion     string         `db:"app_version" json:"app_version"`
@@ -46,4 +57,43 @@ type Device struct {
 	SystemLangCode string         `db:"system_lang_code" json:"system_lang_code"`
 	Proxy          sql.NullString `db:"proxy" json:"proxy"`
 	CreationDate   string         `db:"creation_date" json:"creation_date"`
+	// LastProxy is the proxy used by the last successful connection, see ConnectToBot.
+	LastProxy sql.NullString `db:"last_proxy" json:"last_proxy"`
+}
+
+// Proxy is a proxy of the pool, the failover list of each bot is in bots_proxies.
+type Proxy struct {
+	ID            int64         `db:"id" json:"id"`
+	URL           string        `db:"url" json:"url"`
+	Healthy       bool          `db:"healthy" json:"healthy"`
+	LatencyMs     sql.NullInt64 `db:"latency_ms" json:"latency_ms"`
+	LastCheckedAt sql.NullTime  `db:"last_checked_at" json:"last_checked_at"`
+	LastError     string        `db:"last_error" json:"last_error"`
+}
+
+// decrypt decrypts the password, if it's encrypted.
+func (b *Bot) decrypt(e *Encryption) error {
+	password, err := e.decrypt(b.Password)
+	if err != nil {
+		return fmt.Errorf("couldn't decrypt password of bot %d: %w", b.UserID, err)
+	}
+	b.Password = password
+	return nil
+}
+
+// decrypt decrypts the session string and the session, if they're encrypted.
+func (d *Device) decrypt(e *Encryption) error {
+	sessionString, err := e.decrypt(d.SessionString)
+	if err != nil {
+		return fmt.Errorf("couldn't decrypt session string of bot %d: %w", d.BotUserID, err)
+	}
+	d.SessionString = sessionString
+	if d.SessionData.Valid {
+		sessionData, err := e.decrypt(d.SessionData.String)
+		if err != nil {
+			return fmt.Errorf("couldn't decrypt session of bot %d: %w", d.BotUserID, err)
+		}
+		d.SessionData.String = sessionData
+	}
+	return nil
 }
@@ -0,0 +1,200 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gotd/contrib/storage"
+	"github.com/gotd/td/constant"
+	"github.com/gotd/td/telegram/query/dialogs"
+	"github.com/jmoiron/sqlx"
+)
+
+var _ storage.PeerStorage = (*PeerStorage)(nil)
+
+// PeerStorage implements gotd's contrib `storage.PeerStorage` on the bots_entities table,
+// the peers and their access hashes are kept per bot, so they are only resolved once.
+type PeerStorage struct {
+	db        *sqlx.DB
+	botUserID int64
+}
+
+func NewPeerStorage(db *sqlx.DB, botUserID int64) *PeerStorage {
+	return &PeerStorage{db: db, botUserID: botUserID}
+}
+
+// Add adds the peer, with its username or phone number as resolve key, if it has one.
+func (s *PeerStorage) Add(ctx context.Context, value storage.Peer) error {
+	var key string
+	if keys := value.Keys(); len(keys) > 0 {
+		key = keys[0]
+	}
+	return s.upsert(ctx, s.db, key, value)
+}
+
+func (s *PeerStorage) Find(ctx context.Context, key storage.PeerKey) (storage.Peer, error) {
+	id, err := entityID(key.Kind, key.ID)
+	if err != nil {
+		return storage.Peer{}, err
+	}
+	relationship := BotAndEntityRelationship{}
+	err = s.db.GetContext(ctx, &relationship, "SELECT * FROM bots_entities WHERE entity_id_1=$1 AND entity_id_2=$2", s.botUserID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.Peer{}, storage.ErrPeerNotFound
+	}
+	if err != nil {
+		return storage.Peer{}, err
+	}
+	return relationship.toPeer()
+}
+
+// Assign adds the peer, and associates it to the key, the key is removed from the peer it was
+// associated to before.
+func (s *PeerStorage) Assign(ctx context.Context, key string, value storage.Peer) error {
+	return WithTx(ctx, s.db, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, "UPDATE bots_entities SET resolve_key=NULL WHERE entity_id_1=$1 AND resolve_key=$2", s.botUserID, normalizeResolveKey(key))
+		if err != nil {
+			return err
+		}
+		return s.upsert(ctx, tx, key, value)
+	})
+}
+
+func (s *PeerStorage) Resolve(ctx context.Context, key string) (storage.Peer, error) {
+	relationship := BotAndEntityRelationship{}
+	err := s.db.GetContext(ctx, &relationship, "SELECT * FROM bots_entities WHERE entity_id_1=$1 AND resolve_key=$2 LIMIT 1", s.botUserID, normalizeResolveKey(key))
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.Peer{}, storage.ErrPeerNotFound
+	}
+	if err != nil {
+		return storage.Peer{}, err
+	}
+	return relationship.toPeer()
+}
+
+// Iterate returns an iterator over all the peers of the bot.
+func (s *PeerStorage) Iterate(ctx context.Context) (storage.PeerIterator, error) {
+	var relationships []BotAndEntityRelationship
+	err := s.db.SelectContext(ctx, &relationships, "SELECT * FROM bots_entities WHERE entity_id_1=$1", s.botUserID)
+	if err != nil {
+		return nil, err
+	}
+	return &peerIterator{relationships: relationships, index: -1}, nil
+}
+
+func (s *PeerStorage) upsert(ctx context.Context, db sqlx.ExecerContext, key string, value storage.Peer) error {
+	id, err := entityID(value.Key.Kind, value.Key.ID)
+	if err != nil {
+		return err
+	}
+	data, err := value.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	resolveKey := sql.NullString{String: normalizeResolveKey(key), Valid: key != ""}
+	_, err = db.ExecContext(ctx, `
+            INSERT INTO
+              bots_entities (entity_id_1, entity_id_2, hash, resolve_key, peer)
+            VALUES
+              ($1, $2, $3, $4, $5)
+            ON CONFLICT (entity_id_1, entity_id_2) DO UPDATE SET
+              hash = EXCLUDED.hash,
+              resolve_key = COALESCE(EXCLUDED.resolve_key, bots_entities.resolve_key),
+              peer = EXCLUDED.peer;
+		`, s.botUserID, id, value.Key.AccessHash, resolveKey, string(data))
+	return err
+}
+
+// toPeer decodes the stored peer, entities stored without one, like the channels access
+// hashes of the updates storage, only have their key.
+func (r BotAndEntityRelationship) toPeer() (storage.Peer, error) {
+	if r.Peer.Valid {
+		var peer storage.Peer
+		err := peer.UnmarshalJSON([]byte(r.Peer.String))
+		if errors.Is(err, storage.ErrPeerUnmarshalMustInvalidate) {
+			return storage.Peer{}, storage.ErrPeerNotFound
+		}
+		return peer, err
+	}
+	if !r.Hash.Valid {
+		return storage.Peer{}, storage.ErrPeerNotFound
+	}
+	id := constant.TDLibPeerID(r.SecondEntityID)
+	key := dialogs.DialogKey{ID: id.ToPlain(), AccessHash: r.Hash.Int64}
+	switch {
+	case id.IsUser():
+		key.Kind = dialogs.User
+	case id.IsChat():
+		key.Kind = dialogs.Chat
+	case id.IsChannel():
+		key.Kind = dialogs.Channel
+	default:
+		return storage.Peer{}, fmt.Errorf("invalid entity ID %d", r.SecondEntityID)
+	}
+	return storage.Peer{Version: storage.LatestVersion, Key: key, CreatedAt: time.Now()}, nil
+}
+
+type peerIterator struct {
+	relationships []BotAndEntityRelationship
+	index         int
+	value         storage.Peer
+	err           error
+}
+
+func (i *peerIterator) Next(ctx context.Context) bool {
+	for i.err == nil {
+		i.index++
+		if i.index >= len(i.relationships) {
+			return false
+		}
+		peer, err := i.relationships[i.index].toPeer()
+		if errors.Is(err, storage.ErrPeerNotFound) {
+			continue
+		}
+		if err != nil {
+			i.err = err
+			return false
+		}
+		i.value = peer
+		return true
+	}
+	return false
+}
+
+func (i *peerIterator) Err() error {
+	return i.err
+}
+
+func (i *peerIterator) Value() storage.Peer {
+	return i.value
+}
+
+func (i *peerIterator) Close() error {
+	return nil
+}
+
+// entityID returns the peer ID marked like TDLib does, so users, chats and channels don't
+// collide in bots_entities.
+func entityID(kind dialogs.PeerKind, plainID int64) (int64, error) {
+	var id constant.TDLibPeerID
+	switch kind {
+	case dialogs.User:
+		id.User(plainID)
+	case dialogs.Chat:
+		id.Chat(plainID)
+	case dialogs.Channel:
+		id.Channel(plainID)
+	default:
+		return 0, fmt.Errorf("unknown peer kind %d", kind)
+	}
+	return int64(id), nil
+}
+
+// normalizeResolveKey lowercases usernames, which are case insensitive.
+func normalizeResolveKey(key string) string {
+	return strings.ToLower(strings.TrimPrefix(key, "@"))
+}
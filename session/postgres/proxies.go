@@ -0,0 +1,279 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/prdsrm/std/utils"
+)
+
+// ProxyCheckAddress is the address proxies are probed with, through them: DC 2 by default.
+var ProxyCheckAddress = "149.154.167.51:443"
+
+// ProxyCheckTimeout is the time a proxy has to connect to ProxyCheckAddress.
+const ProxyCheckTimeout = 10 * time.Second
+
+// ProxyConnectTimeout is the time a connection through one of the proxies of a bot has to
+// authorize the account, before the next proxy is used.
+var ProxyConnectTimeout = 30 * time.Second
+
+var (
+	NoHealthyProxyError   = errors.New("no healthy proxy")
+	ProxyUnreachableError = errors.New("couldn't connect through the proxy")
+)
+
+// InsertProxy adds the proxy to the pool, and returns its ID, the existing one if it's already
+// in the pool. The URL must have a scheme, like `socks5://host:port` or `tg://proxy?...`.
+func InsertProxy(db *sqlx.DB, proxyURL string) (int64, error) {
+	return InsertProxyContext(context.Background(), db, proxyURL)
+}
+
+func InsertProxyContext(ctx context.Context, db sqlx.ExtContext, proxyURL string) (int64, error) {
+	// The URL isn't in the errors, it may contain a password.
+	parsed, err := url.Parse(proxyURL)
+	if err != nil || parsed.Scheme == "" {
+		return 0, fmt.Errorf("%w: %s", utils.InvalidProxyURLError, redactProxyURL(proxyURL))
+	}
+	var id int64
+	err = sqlx.GetContext(ctx, db, &id, `
+            INSERT INTO
+              proxies (url)
+            VALUES
+              ($1)
+            ON CONFLICT (url) DO UPDATE SET url=excluded.url
+            RETURNING id;
+		`, proxyURL)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func DeleteProxy(db *sqlx.DB, proxyID int64) error {
+	return DeleteProxyContext(context.Background(), db, proxyID)
+}
+
+func DeleteProxyContext(ctx context.Context, db sqlx.ExtContext, proxyID int64) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM proxies WHERE id=$1", proxyID)
+	return err
+}
+
+// AssignProxyToBot adds the proxy to the failover list of the bot, the proxies are tried by
+// ascending priority.
+func AssignProxyToBot(db *sqlx.DB, botUserID int64, proxyID int64, priority int) error {
+	return AssignProxyToBotContext(context.Background(), db, botUserID, proxyID, priority)
+}
+
+func AssignProxyToBotContext(ctx context.Context, db sqlx.ExtContext, botUserID int64, proxyID int64, priority int) error {
+	_, err := db.ExecContext(ctx, `
+            INSERT INTO
+              bots_proxies (
+                bot_user_id,
+                proxy_id,
+                priority
+              )
+            VALUES
+              ($1, $2, $3)
+            ON CONFLICT (bot_user_id, proxy_id) DO UPDATE SET priority=excluded.priority;
+		`, botUserID, proxyID, priority)
+	return err
+}
+
+func GetAllProxies(db *sqlx.DB) ([]Proxy, error) {
+	return GetAllProxiesContext(context.Background(), db)
+}
+
+func GetAllProxiesContext(ctx context.Context, db sqlx.ExtContext) ([]Proxy, error) {
+	var proxies []Proxy
+	err := sqlx.SelectContext(ctx, db, &proxies, "SELECT * FROM proxies ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	return proxies, nil
+}
+
+// GetBotProxies returns the failover list of the bot, healthy or not, by ascending priority.
+func GetBotProxies(db *sqlx.DB, botUserID int64) ([]Proxy, error) {
+	return GetBotProxiesContext(context.Background(), db, botUserID)
+}
+
+func GetBotProxiesContext(ctx context.Context, db sqlx.ExtContext, botUserID int64) ([]Proxy, error) {
+	var proxies []Proxy
+	query := `
+            SELECT
+              proxies.*
+            FROM
+              proxies
+              JOIN bots_proxies ON bots_proxies.proxy_id = proxies.id
+            WHERE
+              bots_proxies.bot_user_id = $1
+            ORDER BY
+              bots_proxies.priority, proxies.id;
+		`
+	err := sqlx.SelectContext(ctx, db, &proxies, query, botUserID)
+	if err != nil {
+		return nil, err
+	}
+	return proxies, nil
+}
+
+// RecordProxyCheck records the result of a probe of the proxy, nil if it succeeded.
+// The password and the secret of the proxy are redacted from the recorded error.
+func RecordProxyCheck(db *sqlx.DB, proxyID int64, latency time.Duration, checkErr error) error {
+	return RecordProxyCheckContext(context.Background(), db, proxyID, latency, checkErr)
+}
+
+func RecordProxyCheckContext(ctx context.Context, db sqlx.ExtContext, proxyID int64, latency time.Duration, checkErr error) error {
+	if checkErr != nil {
+		var proxyURL string
+		if err := sqlx.GetContext(ctx, db, &proxyURL, "SELECT url FROM proxies WHERE id=$1", proxyID); err != nil {
+			return err
+		}
+		_, err := db.ExecContext(ctx,
+			"UPDATE proxies SET healthy=false, last_checked_at=$1, last_error=$2 WHERE id=$3",
+			healthNow(), redactProxyError(checkErr, proxyURL), proxyID,
+		)
+		return err
+	}
+	_, err := db.ExecContext(ctx,
+		"UPDATE proxies SET healthy=true, latency_ms=$1, last_checked_at=$2, last_error='' WHERE id=$3",
+		latency.Milliseconds(), healthNow(), proxyID,
+	)
+	return err
+}
+
+// RecordDeviceProxy records the proxy used by the last successful connection of the device,
+// empty if it connected directly.
+func RecordDeviceProxy(db *sqlx.DB, sessionString string, proxyURL string) error {
+	return RecordDeviceProxyContext(context.Background(), db, sessionString, proxyURL)
+}
+
+func RecordDeviceProxyContext(ctx context.Context, db sqlx.ExtContext, sessionString string, proxyURL string) error {
+	result, err := db.ExecContext(ctx,
+		"UPDATE devices SET last_proxy=$1 WHERE session_hash=$2 OR session_string=$3",
+		sql.NullString{String: proxyURL, Valid: proxyURL != ""}, sessionHash(sessionString), sessionString,
+	)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return errors.New("device not found, couldn't record its proxy")
+	}
+	return nil
+}
+
+// CheckProxy connects to ProxyCheckAddress through the proxy, and returns how long it took.
+func CheckProxy(ctx context.Context, proxyURL string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, ProxyCheckTimeout)
+	defer cancel()
+	return utils.ProbeProxy(ctx, proxyURL, ProxyCheckAddress)
+}
+
+// CheckProxies probes every proxy of the pool, and records their health and latency.
+// Unhealthy proxies are skipped by ConnectToBot, until they answer again.
+func CheckProxies(db *sqlx.DB) error {
+	return CheckProxiesContext(context.Background(), db)
+}
+
+func CheckProxiesContext(ctx context.Context, db sqlx.ExtContext) error {
+	proxies, err := GetAllProxiesContext(ctx, db)
+	if err != nil {
+		return err
+	}
+	for _, proxy := range proxies {
+		latency, checkErr := CheckProxy(ctx, proxy.URL)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := RecordProxyCheckContext(ctx, db, proxy.ID, latency, checkErr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// proxyCandidates returns the proxies to connect to the bot with, in order: the proxy of the
+// device, then the healthy proxies of the bot. The list is empty when the bot has no proxy, and
+// NoHealthyProxyError is returned when none of them is healthy, so the bot isn't connected to
+// without proxy.
+func proxyCandidates(ctx context.Context, store AccountStore, device *Device) ([]Proxy, error) {
+	proxies, err := store.GetBotProxies(ctx, device.BotUserID)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []Proxy
+	if device.Proxy.String != "" {
+		candidates = append(candidates, Proxy{URL: device.Proxy.String, Healthy: true})
+	}
+	for _, proxy := range proxies {
+		if len(candidates) > 0 && proxy.URL == candidates[0].URL {
+			// The proxy of the device is in the pool too.
+			candidates[0] = proxy
+			continue
+		}
+		candidates = append(candidates, proxy)
+	}
+	var healthy []Proxy
+	for _, proxy := range candidates {
+		if proxy.Healthy {
+			healthy = append(healthy, proxy)
+		}
+	}
+	if len(candidates) > 0 && len(healthy) == 0 {
+		return nil, fmt.Errorf("%w for bot %d", NoHealthyProxyError, device.BotUserID)
+	}
+	return healthy, nil
+}
+
+// isNetworkError reports whether the connection failed because of the network, like a proxy
+// refusing connections, rather than because of Telegram.
+func isNetworkError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// redactProxyURL hides the password of the proxy, and the secret of MTProxy links, for error
+// messages.
+func redactProxyURL(proxyURL string) string {
+	parsed, err := url.Parse(proxyURL)
+	if err != nil {
+		return "(invalid URL)"
+	}
+	if query := parsed.Query(); query.Has("secret") {
+		query.Set("secret", "xxxxx")
+		parsed.RawQuery = query.Encode()
+	}
+	return parsed.Redacted()
+}
+
+// redactProxyError returns the message of the error, without the password of the proxy, and the
+// secret of MTProxy links.
+func redactProxyError(err error, proxyURL string) string {
+	message := strings.ReplaceAll(err.Error(), proxyURL, redactProxyURL(proxyURL))
+	parsed, parseErr := url.Parse(proxyURL)
+	if parseErr != nil {
+		return message
+	}
+	secrets := []string{parsed.Query().Get("secret")}
+	if password, ok := parsed.User.Password(); ok {
+		secrets = append(secrets, password)
+	}
+	for _, secret := range secrets {
+		if secret != "" {
+			message = strings.ReplaceAll(message, secret, "xxxxx")
+		}
+	}
+	return message
+}
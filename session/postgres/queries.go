@@ -6,20 +6,49 @@ import (
 	"github.com/gotd/td/telegram"
 	"github.com/gotd/td/tg"
 	"github.com/jmoiron/sqlx"
-	"github.com/prdsrm/std/session"
 )
 
+// ConnectToBotFromDatabase is like ConnectToBot, on a store encrypted with DefaultEncryption.
 func ConnectToBotFromDatabase(db *sqlx.DB, botModel *Bot, f func(ctx context.Context, client *telegram.Client, dispatcher tg.UpdateDispatcher, options telegram.Options) error) error {
-	device, err := GetRandomDevice(db, botModel.UserID)
+	e, err := DefaultEncryption()
 	if err != nil {
 		return err
 	}
-	flow := session.GetNewDefaultAuthConversator(botModel.PhoneNumber, botModel.Password)
-	err = session.Connect(f, session.Windows(), device.ApiID, device.ApiHash, device.SessionString, device.Proxy.String, flow)
+	return ConnectToBot(NewStore(db, WithEncryption(e)), botModel, f)
+}
+
+// WithTx runs f in a transaction, which is committed if f succeeds, and rolled back otherwise.
+// The queries of this package take a `sqlx.ExtContext`, so they can be run in the transaction.
+func WithTx(ctx context.Context, db *sqlx.DB, f func(tx *sqlx.Tx) error) error {
+	tx, err := db.BeginTxx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	return nil
+	defer tx.Rollback()
+	if err := f(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// InsertNewBotWithDevice inserts the bot and its first device in a single transaction, so a
+// bot is never stored without a device to connect to it.
+func InsertNewBotWithDevice(ctx context.Context, db *sqlx.DB, bot *Bot, device *Device) error {
+	e, err := DefaultEncryption()
+	if err != nil {
+		return err
+	}
+	return insertBotWithDevice(ctx, db, e, bot, device)
+}
+
+func insertBotWithDevice(ctx context.Context, db *sqlx.DB, e *Encryption, bot *Bot, device *Device) error {
+	return WithTx(ctx, db, func(tx *sqlx.Tx) error {
+		err := insertBot(ctx, tx, e, bot.PhoneNumber, bot.Username, bot.Password, bot.UserID, bot.Title)
+		if err != nil {
+			return err
+		}
+		return insertDevice(ctx, tx, e, bot.UserID, device.ApiID, device.ApiHash, device.SessionString, device.DeviceModel, device.SystemVersion, device.AppVersion, device.LangPack, device.LangCode, device.SystemLangCode, device.Proxy.String)
+	})
 }
 
 func InsertNewBot(
@@ -30,7 +59,31 @@ func InsertNewBot(
 	userID int64,
 	title string,
 ) error {
-	_, err := db.Exec(`
+	return InsertNewBotContext(context.Background(), db, phone, username, password, userID, title)
+}
+
+func InsertNewBotContext(
+	ctx context.Context,
+	db sqlx.ExtContext,
+	phone string,
+	username string,
+	password string,
+	userID int64,
+	title string,
+) error {
+	e, err := DefaultEncryption()
+	if err != nil {
+		return err
+	}
+	return insertBot(ctx, db, e, phone, username, password, userID, title)
+}
+
+func insertBot(ctx context.Context, db sqlx.ExtContext, e *Encryption, phone string, username string, password string, userID int64, title string) error {
+	password, err := e.encrypt(password)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `
             INSERT INTO
               bots (
                 phone_number,
@@ -49,7 +102,11 @@ func InsertNewBot(
 }
 
 func DeleteBotByUserID(db *sqlx.DB, botUserID int64) error {
-	_, err := db.Exec("DELETE FROM bots WHERE user_id=$1", botUserID)
+	return DeleteBotByUserIDContext(context.Background(), db, botUserID)
+}
+
+func DeleteBotByUserIDContext(ctx context.Context, db sqlx.ExtContext, botUserID int64) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM bots WHERE user_id=$1", botUserID)
 	if err != nil {
 		return err
 	}
@@ -57,46 +114,152 @@ func DeleteBotByUserID(db *sqlx.DB, botUserID int64) error {
 }
 
 func GetAllBots(db *sqlx.DB) ([]Bot, error) {
+	return GetAllBotsContext(context.Background(), db)
+}
+
+func GetAllBotsContext(ctx context.Context, db sqlx.ExtContext) ([]Bot, error) {
+	e, err := DefaultEncryption()
+	if err != nil {
+		return nil, err
+	}
+	return getAllBots(ctx, db, e)
+}
+
+func getAllBots(ctx context.Context, db sqlx.ExtContext, e *Encryption) ([]Bot, error) {
 	var bots []Bot
 	query := `SELECT * FROM bots`
-	err := db.Select(&bots, query)
+	err := sqlx.SelectContext(ctx, db, &bots, query)
 	if err != nil {
 		return nil, err
 	}
+	for i := range bots {
+		if err := bots[i].decrypt(e); err != nil {
+			return nil, err
+		}
+	}
 	return bots, nil
 }
 
 func GetRandomBot(db *sqlx.DB) (*Bot, error) {
+	return GetRandomBotContext(context.Background(), db)
+}
+
+func GetRandomBotContext(ctx context.Context, db sqlx.ExtContext) (*Bot, error) {
+	e, err := DefaultEncryption()
+	if err != nil {
+		return nil, err
+	}
+	return getRandomBot(ctx, db, e)
+}
+
+func getRandomBot(ctx context.Context, db sqlx.ExtContext, e *Encryption) (*Bot, error) {
 	bot := Bot{}
-	query := `SELECT * FROM bots ORDER BY RANDOM() LIMIT 1`
-	err := db.Get(&bot, query)
+	// Accounts which are unauthorized, or waiting for a FLOOD_WAIT, are skipped.
+	query := `SELECT * FROM bots WHERE ` + healthyBotsCondition + ` ORDER BY RANDOM() LIMIT 1`
+	err := sqlx.GetContext(ctx, db, &bot, query, healthNow())
 	if err != nil {
 		return nil, err
 	}
+	if err := bot.decrypt(e); err != nil {
+		return nil, err
+	}
 	return &bot, nil
 }
 
 func GetBotByUserID(db *sqlx.DB, botUserID int64) (*Bot, error) {
+	return GetBotByUserIDContext(context.Background(), db, botUserID)
+}
+
+func GetBotByUserIDContext(ctx context.Context, db sqlx.ExtContext, botUserID int64) (*Bot, error) {
+	e, err := DefaultEncryption()
+	if err != nil {
+		return nil, err
+	}
+	return getBotByUserID(ctx, db, e, botUserID)
+}
+
+func getBotByUserID(ctx context.Context, db sqlx.ExtContext, e *Encryption, botUserID int64) (*Bot, error) {
 	bot := Bot{}
-	err := db.Get(&bot, "SELECT * FROM bots WHERE user_id=$1", botUserID)
+	err := sqlx.GetContext(ctx, db, &bot, "SELECT * FROM bots WHERE user_id=$1", botUserID)
 	if err != nil {
 		return nil, err
 	}
+	if err := bot.decrypt(e); err != nil {
+		return nil, err
+	}
 	return &bot, nil
 }
 
 func GetRandomDevice(db *sqlx.DB, botUserID int64) (*Device, error) {
+	return GetRandomDeviceContext(context.Background(), db, botUserID)
+}
+
+func GetRandomDeviceContext(ctx context.Context, db sqlx.ExtContext, botUserID int64) (*Device, error) {
+	e, err := DefaultEncryption()
+	if err != nil {
+		return nil, err
+	}
+	return getRandomDevice(ctx, db, e, botUserID)
+}
+
+func getRandomDevice(ctx context.Context, db sqlx.ExtContext, e *Encryption, botUserID int64) (*Device, error) {
 	device := Device{}
 	query := `SELECT * FROM devices WHERE bot_user_id=$1 ORDER BY RANDOM() LIMIT 1`
-	err := db.Get(&device, query, botUserID)
+	err := sqlx.GetContext(ctx, db, &device, query, botUserID)
 	if err != nil {
 		return nil, err
 	}
+	if err := device.decrypt(e); err != nil {
+		return nil, err
+	}
 	return &device, nil
 }
 
+func GetDevicesByBotUserID(db *sqlx.DB, botUserID int64) ([]Device, error) {
+	return GetDevicesByBotUserIDContext(context.Background(), db, botUserID)
+}
+
+func GetDevicesByBotUserIDContext(ctx context.Context, db sqlx.ExtContext, botUserID int64) ([]Device, error) {
+	e, err := DefaultEncryption()
+	if err != nil {
+		return nil, err
+	}
+	return getDevicesByBotUserID(ctx, db, e, botUserID)
+}
+
+func getDevicesByBotUserID(ctx context.Context, db sqlx.ExtContext, e *Encryption, botUserID int64) ([]Device, error) {
+	var devices []Device
+	err := sqlx.SelectContext(ctx, db, &devices, "SELECT * FROM devices WHERE bot_user_id=$1", botUserID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range devices {
+		if err := devices[i].decrypt(e); err != nil {
+			return nil, err
+		}
+	}
+	return devices, nil
+}
+
 func InsertNewDevice(db *sqlx.DB, userID int64, apiID int, apiHash string, sessionString string, deviceModel string, systemVersion string, appVersion string, langPack string, langCode string, systemLangCode string, proxy string) error {
-	_, err := db.Exec(`
+	return InsertNewDeviceContext(context.Background(), db, userID, apiID, apiHash, sessionString, deviceModel, systemVersion, appVersion, langPack, langCode, systemLangCode, proxy)
+}
+
+func InsertNewDeviceContext(ctx context.Context, db sqlx.ExtContext, userID int64, apiID int, apiHash string, sessionString string, deviceModel string, systemVersion string, appVersion string, langPack string, langCode string, systemLangCode string, proxy string) error {
+	e, err := DefaultEncryption()
+	if err != nil {
+		return err
+	}
+	return insertDevice(ctx, db, e, userID, apiID, apiHash, sessionString, deviceModel, systemVersion, appVersion, langPack, langCode, systemLangCode, proxy)
+}
+
+func insertDevice(ctx context.Context, db sqlx.ExtContext, e *Encryption, userID int64, apiID int, apiHash string, sessionString string, deviceModel string, systemVersion string, appVersion string, langPack string, langCode string, systemLangCode string, proxy string) error {
+	hash := sessionHash(sessionString)
+	sessionString, err := e.encrypt(sessionString)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `
             INSERT INTO
               devices (
                 bot_user_id,
@@ -109,11 +272,12 @@ func InsertNewDevice(db *sqlx.DB, userID int64, apiID int, apiHash string, sessi
 				lang_pack,
 				lang_code,
 				system_lang_code,
-				proxy
+				proxy,
+				session_hash
               )
             VALUES
-              ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11);
-		`, userID, apiID, apiHash, sessionString, deviceModel, systemVersion, appVersion, langPack, langCode, systemLangCode, proxy)
+              ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12);
+		`, userID, apiID, apiHash, sessionString, deviceModel, systemVersion, appVersion, langPack, langCode, systemLangCode, proxy, hash)
 	if err != nil {
 		return err
 	}
@@ -121,7 +285,12 @@ func InsertNewDevice(db *sqlx.DB, userID int64, apiID int, apiHash string, sessi
 }
 
 func DeleteDeviceBySessionString(db *sqlx.DB, session string) error {
-	_, err := db.Exec("DELETE FROM bots WHERE session_string=$1", session)
+	return DeleteDeviceBySessionStringContext(context.Background(), db, session)
+}
+
+func DeleteDeviceBySessionStringContext(ctx context.Context, db sqlx.ExtContext, session string) error {
+	// Devices stored before the session hash was added are found by their session string.
+	_, err := db.ExecContext(ctx, "DELETE FROM devices WHERE session_hash=$1 OR session_string=$2", sessionHash(session), session)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,28 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// OpenSQLiteStore opens, or creates, the SQLite database at path, and migrates it to the same
+// schema as the postgres one.
+// It lets small deployments, and tests, run without a postgres server.
+func OpenSQLiteStore(path string, opts ...StoreOption) (*Store, error) {
+	// Foreign keys are needed to delete the devices, states and entities of bots.
+	db, err := sqlx.Connect("sqlite3", "file:"+path+"?_foreign_keys=on")
+	if err != nil {
+		return nil, fmt.Errorf("error, couldn't open sqlite database, %w", err)
+	}
+	// SQLite doesn't support concurrent writes.
+	db.SetMaxOpenConns(1)
+
+	if err := Migrate(context.Background(), db, 0); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return NewStore(db, opts...), nil
+}
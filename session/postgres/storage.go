@@ -0,0 +1,112 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+
+	tdsession "github.com/gotd/td/session"
+	"github.com/jmoiron/sqlx"
+	"github.com/prdsrm/std/session"
+)
+
+var _ DeviceSessionStorage = (*SessionStorage)(nil)
+
+// SessionStorage implements gotd's session storage on a devices row, the session is stored
+// as gotd JSON and as a Telethon string session, so the row stays usable when gotd migrates
+// to another DC or creates a new auth key.
+// Goroutine-safe.
+type SessionStorage struct {
+	db         *sqlx.DB
+	encryption *Encryption
+	// encryptionErr is the error of DefaultEncryption, returned by the storage methods.
+	encryptionErr error
+	// Session string of the row, updated on every store.
+	sessionString string
+	mux           sync.Mutex
+}
+
+// NewSessionStorage creates the session storage of the device, the session is encrypted with
+// DefaultEncryption, use Store.SessionStorage for the encryption of a store.
+func NewSessionStorage(db *sqlx.DB, device *Device) *SessionStorage {
+	encryption, err := DefaultEncryption()
+	return &SessionStorage{db: db, encryption: encryption, encryptionErr: err, sessionString: device.SessionString}
+}
+
+// SessionString returns the session string of the device, the last one stored.
+func (s *SessionStorage) SessionString() string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.sessionString
+}
+
+// LoadSession loads the gotd session of the device, `session.ErrNotFound` is returned until
+// gotd stored one, the session string is used instead.
+func (s *SessionStorage) LoadSession(ctx context.Context) ([]byte, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.encryptionErr != nil {
+		return nil, s.encryptionErr
+	}
+
+	var data sql.NullString
+	err := s.db.GetContext(ctx, &data, "SELECT session_data FROM devices WHERE session_hash=$1 OR session_string=$2", sessionHash(s.sessionString), s.sessionString)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("device not found: %w", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !data.Valid || data.String == "" {
+		return nil, tdsession.ErrNotFound
+	}
+	decrypted, err := s.encryption.decrypt(data.String)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(decrypted), nil
+}
+
+// StoreSession stores the gotd session and its Telethon string session in the devices row.
+func (s *SessionStorage) StoreSession(ctx context.Context, data []byte) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.encryptionErr != nil {
+		return s.encryptionErr
+	}
+
+	sessionData, err := session.ParseSessionString(string(data))
+	if err != nil {
+		return err
+	}
+	sessionString, err := session.EncodeSessionToTelethonString(sessionData)
+	if err != nil {
+		return err
+	}
+	encryptedSessionString, err := s.encryption.encrypt(sessionString)
+	if err != nil {
+		return err
+	}
+	encryptedData, err := s.encryption.encrypt(string(data))
+	if err != nil {
+		return err
+	}
+	result, err := s.db.ExecContext(ctx,
+		"UPDATE devices SET session_string=$1, session_data=$2, session_hash=$3 WHERE session_hash=$4 OR session_string=$5",
+		encryptedSessionString, encryptedData, sessionHash(sessionString), sessionHash(s.sessionString), s.sessionString,
+	)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return errors.New("device not found, couldn't store session")
+	}
+	s.sessionString = sessionString
+	return nil
+}
@@ -0,0 +1,397 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gotd/contrib/storage"
+	tdsession "github.com/gotd/td/session"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/telegram/updates"
+	"github.com/gotd/td/tg"
+	"github.com/jmoiron/sqlx"
+	"github.com/prdsrm/std/session"
+)
+
+// AccountStore stores the accounts (bots), their devices, and what's needed to connect to
+// them: updates states and peers.
+type AccountStore interface {
+	InsertBot(ctx context.Context, bot *Bot) error
+	GetBot(ctx context.Context, userID int64) (*Bot, error)
+	GetRandomBot(ctx context.Context) (*Bot, error)
+	GetAllBots(ctx context.Context) ([]Bot, error)
+	DeleteBot(ctx context.Context, userID int64) error
+	// InsertBotWithDevice inserts the bot and its first device atomically.
+	InsertBotWithDevice(ctx context.Context, bot *Bot, device *Device) error
+	// GetHealthyBots returns the bots which are authorized, and not waiting for a FLOOD_WAIT,
+	// GetRandomBot only returns one of them.
+	GetHealthyBots(ctx context.Context) ([]Bot, error)
+	// RecordBotConnection records the result of a connection, nil if it succeeded.
+	RecordBotConnection(ctx context.Context, userID int64, connectErr error) error
+	RecordBotFloodWait(ctx context.Context, userID int64, until time.Time) error
+
+	InsertDevice(ctx context.Context, device *Device) error
+	GetRandomDevice(ctx context.Context, botUserID int64) (*Device, error)
+	GetDevices(ctx context.Context, botUserID int64) ([]Device, error)
+	DeleteDevice(ctx context.Context, sessionString string) error
+
+	// GetBotProxies returns the failover list of proxies of the bot, by ascending priority.
+	GetBotProxies(ctx context.Context, botUserID int64) ([]Proxy, error)
+	// RecordProxyCheck records the result of a probe of the proxy, nil if it succeeded.
+	RecordProxyCheck(ctx context.Context, proxyID int64, latency time.Duration, checkErr error) error
+	// RecordDeviceProxy records the proxy used by the last successful connection of the device,
+	// found by its current session string, see DeviceSessionStorage.
+	RecordDeviceProxy(ctx context.Context, sessionString string, proxyURL string) error
+
+	// SessionStorage returns the gotd session storage of the device.
+	SessionStorage(device *Device) DeviceSessionStorage
+	// UpdatesStorage returns the updates state storage, shared by all the bots.
+	UpdatesStorage() UpdatesStateStorage
+	// PeerStorage returns the peer storage of the bot.
+	PeerStorage(botUserID int64) storage.PeerStorage
+
+	Close() error
+}
+
+// DeviceSessionStorage is the gotd session storage of a device, which rewrites its session
+// string when gotd stores a new session.
+type DeviceSessionStorage interface {
+	tdsession.Storage
+	// SessionString returns the current session string of the device.
+	SessionString() string
+}
+
+// ConnectionConfigurer is implemented by the stores changing the options of the connections
+// made by ConnectToBot, like Store with WithConnectionOptions.
+type ConnectionConfigurer interface {
+	ConfigureConnection(opts *session.ConnectOptions)
+}
+
+// UpdatesStateStorage keeps the updates state, and the channels access hashes.
+type UpdatesStateStorage interface {
+	updates.StateStorage
+	updates.ChannelAccessHasher
+}
+
+var _ AccountStore = (*Store)(nil)
+
+// Store implements AccountStore with the queries of this package, on Postgres or on SQLite,
+// see OpenSQLiteStore.
+// NOTE: SQLite binds `$n` placeholders in the order they appear, so the queries must use
+// them in order.
+type Store struct {
+	db         *sqlx.DB
+	encryption *Encryption
+	// configureConnection changes the options of the connections, nil by default.
+	configureConnection func(opts *session.ConnectOptions)
+}
+
+// StoreOption configures a Store.
+type StoreOption func(s *Store)
+
+// WithEncryption encrypts the passwords and sessions stored by the store, a nil encryption keeps
+// them in plain text.
+func WithEncryption(encryption *Encryption) StoreOption {
+	return func(s *Store) {
+		s.encryption = encryption
+	}
+}
+
+// WithConnectionOptions changes the options of the connections made by ConnectToBot with the
+// store, like the logger, or the datacenters to connect to.
+func WithConnectionOptions(configure func(opts *session.ConnectOptions)) StoreOption {
+	return func(s *Store) {
+		s.configureConnection = configure
+	}
+}
+
+// NewStore creates a new store on a database opened with OpenDBConnection.
+func NewStore(db *sqlx.DB, opts ...StoreOption) *Store {
+	s := &Store{db: db}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// DB returns the database of the store.
+func (s *Store) DB() *sqlx.DB {
+	return s.db
+}
+
+func (s *Store) InsertBot(ctx context.Context, bot *Bot) error {
+	return insertBot(ctx, s.db, s.encryption, bot.PhoneNumber, bot.Username, bot.Password, bot.UserID, bot.Title)
+}
+
+func (s *Store) GetBot(ctx context.Context, userID int64) (*Bot, error) {
+	return getBotByUserID(ctx, s.db, s.encryption, userID)
+}
+
+func (s *Store) GetRandomBot(ctx context.Context) (*Bot, error) {
+	return getRandomBot(ctx, s.db, s.encryption)
+}
+
+func (s *Store) GetAllBots(ctx context.Context) ([]Bot, error) {
+	return getAllBots(ctx, s.db, s.encryption)
+}
+
+func (s *Store) DeleteBot(ctx context.Context, userID int64) error {
+	return DeleteBotByUserIDContext(ctx, s.db, userID)
+}
+
+func (s *Store) InsertBotWithDevice(ctx context.Context, bot *Bot, device *Device) error {
+	return insertBotWithDevice(ctx, s.db, s.encryption, bot, device)
+}
+
+func (s *Store) GetHealthyBots(ctx context.Context) ([]Bot, error) {
+	return getHealthyBots(ctx, s.db, s.encryption)
+}
+
+func (s *Store) RecordBotConnection(ctx context.Context, userID int64, connectErr error) error {
+	return RecordBotConnectionContext(ctx, s.db, userID, connectErr)
+}
+
+func (s *Store) RecordBotFloodWait(ctx context.Context, userID int64, until time.Time) error {
+	return RecordBotFloodWaitContext(ctx, s.db, userID, until)
+}
+
+func (s *Store) InsertDevice(ctx context.Context, device *Device) error {
+	return insertDevice(ctx, s.db, s.encryption, device.BotUserID, device.ApiID, device.ApiHash, device.SessionString, device.DeviceModel, device.SystemVersion, device.AppVersion, device.LangPack, device.LangCode, device.SystemLangCode, device.Proxy.String)
+}
+
+func (s *Store) GetRandomDevice(ctx context.Context, botUserID int64) (*Device, error) {
+	return getRandomDevice(ctx, s.db, s.encryption, botUserID)
+}
+
+func (s *Store) GetDevices(ctx context.Context, botUserID int64) ([]Device, error) {
+	return getDevicesByBotUserID(ctx, s.db, s.encryption, botUserID)
+}
+
+func (s *Store) DeleteDevice(ctx context.Context, sessionString string) error {
+	return DeleteDeviceBySessionStringContext(ctx, s.db, sessionString)
+}
+
+func (s *Store) GetBotProxies(ctx context.Context, botUserID int64) ([]Proxy, error) {
+	return GetBotProxiesContext(ctx, s.db, botUserID)
+}
+
+func (s *Store) RecordProxyCheck(ctx context.Context, proxyID int64, latency time.Duration, checkErr error) error {
+	return RecordProxyCheckContext(ctx, s.db, proxyID, latency, checkErr)
+}
+
+func (s *Store) RecordDeviceProxy(ctx context.Context, sessionString string, proxyURL string) error {
+	return RecordDeviceProxyContext(ctx, s.db, sessionString, proxyURL)
+}
+
+func (s *Store) SessionStorage(device *Device) DeviceSessionStorage {
+	storage := NewSessionStorage(s.db, device)
+	storage.encryption = s.encryption
+	return storage
+}
+
+func (s *Store) ConfigureConnection(opts *session.ConnectOptions) {
+	if s.configureConnection != nil {
+		s.configureConnection(opts)
+	}
+}
+
+func (s *Store) UpdatesStorage() UpdatesStateStorage {
+	return NewUpdatesStorage(s.db)
+}
+
+func (s *Store) PeerStorage(botUserID int64) storage.PeerStorage {
+	return NewPeerStorage(s.db, botUserID)
+}
+
+// Account is a bot and its devices, as exported by ExportAccounts.
+type Account struct {
+	Bot     Bot      `json:"bot"`
+	Devices []Device `json:"devices"`
+}
+
+// ExportAccounts returns all the bots and their devices, decrypted.
+func (s *Store) ExportAccounts(ctx context.Context) ([]Account, error) {
+	bots, err := s.GetAllBots(ctx)
+	if err != nil {
+		return nil, err
+	}
+	accounts := make([]Account, 0, len(bots))
+	for _, bot := range bots {
+		devices, err := s.GetDevices(ctx, bot.UserID)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, Account{Bot: bot, Devices: devices})
+	}
+	return accounts, nil
+}
+
+// ImportAccounts inserts the bots and their devices in a single transaction, so nothing is
+// imported if one of them fails.
+func (s *Store) ImportAccounts(ctx context.Context, accounts []Account) error {
+	return WithTx(ctx, s.db, func(tx *sqlx.Tx) error {
+		for _, a := range accounts {
+			err := insertBot(ctx, tx, s.encryption, a.Bot.PhoneNumber, a.Bot.Username, a.Bot.Password, a.Bot.UserID, a.Bot.Title)
+			if err != nil {
+				return fmt.Errorf("couldn't import bot %d: %w", a.Bot.UserID, err)
+			}
+			for _, d := range a.Devices {
+				err := insertDevice(ctx, tx, s.encryption, a.Bot.UserID, d.ApiID, d.ApiHash, d.SessionString, d.DeviceModel, d.SystemVersion, d.AppVersion, d.LangPack, d.LangCode, d.SystemLangCode, d.Proxy.String)
+				if err != nil {
+					return fmt.Errorf("couldn't import device of bot %d: %w", a.Bot.UserID, err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// ConnectToBot connects to the bot with one of its devices, the session, the updates state and
+// the peers are kept in the store.
+func ConnectToBot(store AccountStore, botModel *Bot, f func(ctx context.Context, client *telegram.Client, dispatcher tg.UpdateDispatcher, options telegram.Options) error) error {
+	return ConnectToBotContext(context.Background(), store, botModel, f)
+}
+
+// ConnectToBotContext is like ConnectToBot, but the connection is stopped when the context is
+// cancelled.
+// The health of the bot is recorded: successful connections, errors and FLOOD_WAITs.
+// The proxy of the device is used first, then the healthy proxies of the bot, see
+// AssignProxyToBot. When there are several of them, the next one is used when the account isn't
+// authorized within ProxyConnectTimeout, or on network errors, and the proxy used is recorded in
+// the device.
+func ConnectToBotContext(ctx context.Context, store AccountStore, botModel *Bot, f func(ctx context.Context, client *telegram.Client, dispatcher tg.UpdateDispatcher, options telegram.Options) error) error {
+	return ConnectToBotWithPeers(ctx, store, botModel, func(ctx context.Context, client *telegram.Client, dispatcher tg.UpdateDispatcher, options telegram.Options, peers storage.PeerStorage) error {
+		return f(ctx, client, dispatcher, options)
+	})
+}
+
+// ConnectToBotWithPeers is like ConnectToBotContext, f also receives the peer storage of the bot,
+// to pass to the senders with `utils.WithPeerStorage`, so usernames are only resolved once.
+func ConnectToBotWithPeers(ctx context.Context, store AccountStore, botModel *Bot, f func(ctx context.Context, client *telegram.Client, dispatcher tg.UpdateDispatcher, options telegram.Options, peers storage.PeerStorage) error) error {
+	device, err := store.GetRandomDevice(ctx, botModel.UserID)
+	if err != nil {
+		return err
+	}
+	proxies, err := proxyCandidates(ctx, store, device)
+	if err != nil {
+		return err
+	}
+	// The session storage is shared by the attempts: gotd may store a new session before the
+	// account is authorized, then the row is only found with the session string it stored.
+	sessionStorage := store.SessionStorage(device)
+	switch len(proxies) {
+	case 0:
+		err = connectToBot(ctx, store, botModel, device, sessionStorage, "", 0, f)
+	case 1:
+		err = connectToBot(ctx, store, botModel, device, sessionStorage, proxies[0].URL, 0, f)
+	default:
+		var errs []error
+		for _, proxy := range proxies {
+			err = connectToBot(ctx, store, botModel, device, sessionStorage, proxy.URL, ProxyConnectTimeout, f)
+			if !errors.Is(err, ProxyUnreachableError) || ctx.Err() != nil {
+				break
+			}
+			errs = append(errs, err)
+			if proxy.ID != 0 {
+				if recordErr := store.RecordProxyCheck(ctx, proxy.ID, 0, err); recordErr != nil {
+					return errors.Join(err, recordErr)
+				}
+			}
+		}
+		if len(errs) == len(proxies) {
+			err = fmt.Errorf("%w for bot %d: %w", NoHealthyProxyError, botModel.UserID, errors.Join(errs...))
+		}
+	}
+	if err != nil {
+		// The context may be cancelled already.
+		if recordErr := store.RecordBotConnection(context.Background(), botModel.UserID, err); recordErr != nil {
+			return errors.Join(err, recordErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// connectToBot connects to the bot with the device and its session storage, through the proxy.
+// With a timeout, the connection is stopped if the account isn't authorized in time. Then, like
+// for network errors before the account is authorized, a ProxyUnreachableError is returned, so
+// another proxy can be tried.
+func connectToBot(ctx context.Context, store AccountStore, botModel *Bot, device *Device, sessionStorage DeviceSessionStorage, proxy string, timeout time.Duration, f func(ctx context.Context, client *telegram.Client, dispatcher tg.UpdateDispatcher, options telegram.Options, peers storage.PeerStorage) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mux        sync.Mutex
+		authorized bool
+		timedOut   bool
+	)
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, func() {
+			mux.Lock()
+			defer mux.Unlock()
+			if !authorized {
+				timedOut = true
+				cancel()
+			}
+		})
+		defer timer.Stop()
+	}
+	peers := store.PeerStorage(botModel.UserID)
+	connected := func(ctx context.Context, client *telegram.Client, dispatcher tg.UpdateDispatcher, options telegram.Options) error {
+		mux.Lock()
+		authorized = !timedOut
+		mux.Unlock()
+		if !authorized {
+			return ctx.Err()
+		}
+		// The account is authorized once f is called.
+		if err := store.RecordBotConnection(ctx, botModel.UserID, nil); err != nil {
+			return err
+		}
+		// The session string may have been rewritten by gotd since the device was read.
+		if err := store.RecordDeviceProxy(ctx, sessionStorage.SessionString(), proxy); err != nil {
+			return err
+		}
+		return f(ctx, client, dispatcher, options, peers)
+	}
+	flow := session.GetNewDefaultAuthConversator(botModel.PhoneNumber, botModel.Password)
+	// The updates state is kept in the database, so the updates are resumed on restart.
+	updatesStorage := store.UpdatesStorage()
+	opts := session.ConnectOptions{
+		Device:        session.Windows(),
+		APIID:         device.ApiID,
+		APIHash:       device.ApiHash,
+		SessionString: sessionStorage.SessionString(),
+		Proxy:         proxy,
+		Flow:          flow,
+		// Sessions refreshed by gotd are written back to the device.
+		Storage:        sessionStorage,
+		UpdatesStorage: updatesStorage,
+		AccessHasher:   updatesStorage,
+		// Peers are kept in bots_entities, so usernames are only resolved once.
+		PeerStorage: peers,
+		Middlewares: []telegram.Middleware{floodWaitRecorder(store, botModel.UserID)},
+	}
+	if configurer, ok := store.(ConnectionConfigurer); ok {
+		configurer.ConfigureConnection(&opts)
+	}
+	err := session.ConnectWithOptions(ctx, connected, opts)
+	mux.Lock()
+	defer mux.Unlock()
+	switch {
+	case err == nil || authorized:
+		return err
+	case timedOut:
+		return fmt.Errorf("%w: %s: not authorized after %s", ProxyUnreachableError, redactProxyURL(proxy), timeout)
+	case isNetworkError(err):
+		return fmt.Errorf("%w: %s: %w", ProxyUnreachableError, redactProxyURL(proxy), err)
+	default:
+		return err
+	}
+}
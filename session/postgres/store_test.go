@@ -0,0 +1,162 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/gotd/contrib/storage"
+	"github.com/gotd/td/crypto"
+	tdsession "github.com/gotd/td/session"
+	"github.com/gotd/td/telegram/query/dialogs"
+	"github.com/gotd/td/telegram/updates"
+	"github.com/prdsrm/std/session"
+)
+
+func testSessionData(dc int, addr string) *tdsession.Data {
+	key := make([]byte, 256)
+	for i := range key {
+		key[i] = byte(i + dc)
+	}
+	var authKey crypto.Key
+	copy(authKey[:], key)
+	id := authKey.ID()
+	return &tdsession.Data{DC: dc, Addr: addr, AuthKey: key, AuthKeyID: id[:]}
+}
+
+func TestSQLiteStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := OpenSQLiteStore(filepath.Join(t.TempDir(), "accounts.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	bot := &Bot{UserID: 1, PhoneNumber: "33600000000", Username: "account", Title: "A"}
+	sessionString, err := session.EncodeSessionToTelethonString(testSessionData(2, "149.154.167.50:443"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstDevice := &Device{
+		ApiID:         2040,
+		ApiHash:       "b18441a1ff607e10a989891a5462e627",
+		SessionString: sessionString,
+	}
+	if err := store.InsertBotWithDevice(ctx, bot, firstDevice); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := store.GetBot(ctx, bot.UserID); err != nil || *got != *bot {
+		t.Fatalf("unexpected bot: %+v, %v", got, err)
+	}
+	// The bot isn't inserted if its device can't be.
+	if err := store.InsertBotWithDevice(ctx, &Bot{UserID: 2, PhoneNumber: "33600000001"}, firstDevice); err == nil {
+		t.Fatal("expected an error with a duplicate device")
+	}
+	if _, err := store.GetBot(ctx, 2); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected the bot to be rolled back, got %v", err)
+	}
+	device, err := store.GetRandomDevice(ctx, bot.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device.SessionString != sessionString {
+		t.Fatalf("unexpected device: %+v", device)
+	}
+
+	// Session refreshed by gotd.
+	sessionStorage := store.SessionStorage(device)
+	if _, err := sessionStorage.LoadSession(ctx); !errors.Is(err, tdsession.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	data, err := session.Encode(testSessionData(4, "149.154.167.91:443"), session.FormatGotd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sessionStorage.StoreSession(ctx, []byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err := sessionStorage.LoadSession(ctx); err != nil || string(loaded) != data {
+		t.Fatalf("unexpected session: %s, %v", loaded, err)
+	}
+	device, err = store.GetRandomDevice(ctx, bot.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshed, err := session.ParseTelethonSession(device.SessionString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshed.DC != 4 {
+		t.Fatalf("session string wasn't updated: DC %d", refreshed.DC)
+	}
+
+	// Updates state.
+	updatesStorage := store.UpdatesStorage()
+	if err := updatesStorage.SetPts(ctx, bot.UserID, 1); err == nil {
+		t.Fatal("expected an error without state")
+	}
+	if err := updatesStorage.SetState(ctx, bot.UserID, updates.State{Pts: 1, Qts: 2, Date: 3, Seq: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if err := updatesStorage.SetDateSeq(ctx, bot.UserID, 5, 6); err != nil {
+		t.Fatal(err)
+	}
+	state, found, err := updatesStorage.GetState(ctx, bot.UserID)
+	if err != nil || !found || state != (updates.State{Pts: 1, Qts: 2, Date: 5, Seq: 6}) {
+		t.Fatalf("unexpected state: %+v, %v", state, err)
+	}
+	if err := updatesStorage.SetChannelAccessHash(ctx, bot.UserID, 100, 7); err != nil {
+		t.Fatal(err)
+	}
+
+	// Peers.
+	peers := store.PeerStorage(bot.UserID)
+	if _, err := peers.Resolve(ctx, "channel"); !errors.Is(err, storage.ErrPeerNotFound) {
+		t.Fatalf("expected ErrPeerNotFound, got %v", err)
+	}
+	peer := storage.Peer{Version: storage.LatestVersion, Key: dialogs.DialogKey{Kind: dialogs.Channel, ID: 100, AccessHash: 8}}
+	if err := peers.Assign(ctx, "Channel", peer); err != nil {
+		t.Fatal(err)
+	}
+	resolved, err := peers.Resolve(ctx, "@channel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.Key != peer.Key {
+		t.Fatalf("unexpected peer: %+v", resolved.Key)
+	}
+	if hash, found, err := updatesStorage.GetChannelAccessHash(ctx, bot.UserID, 100); err != nil || !found || hash != 8 {
+		t.Fatalf("unexpected access hash: %d, %v", hash, err)
+	}
+	// The stored peer is kept while the access hash doesn't change, and isn't resolved with the old
+	// one after.
+	if err := updatesStorage.SetChannelAccessHash(ctx, bot.UserID, 100, 8); err != nil {
+		t.Fatal(err)
+	}
+	if resolved, err := peers.Resolve(ctx, "channel"); err != nil || resolved.Key != peer.Key {
+		t.Fatalf("unexpected peer: %+v, %v", resolved.Key, err)
+	}
+	if err := updatesStorage.SetChannelAccessHash(ctx, bot.UserID, 100, 9); err != nil {
+		t.Fatal(err)
+	}
+	resolved, err = peers.Resolve(ctx, "channel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.Key.AccessHash != 9 {
+		t.Fatalf("resolved with the old access hash: %d", resolved.Key.AccessHash)
+	}
+
+	// Everything is deleted with the bot.
+	if err := store.DeleteBot(ctx, bot.UserID); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, err := updatesStorage.GetState(ctx, bot.UserID); err != nil || found {
+		t.Fatalf("state wasn't deleted: %v", err)
+	}
+	if _, err := peers.Resolve(ctx, "channel"); !errors.Is(err, storage.ErrPeerNotFound) {
+		t.Fatalf("expected ErrPeerNotFound, got %v", err)
+	}
+}
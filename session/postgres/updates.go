@@ -0,0 +1,173 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/gotd/td/constant"
+	"github.com/gotd/td/telegram/updates"
+	"github.com/jmoiron/sqlx"
+)
+
+var _ updates.StateStorage = (*UpdatesStorage)(nil)
+var _ updates.ChannelAccessHasher = (*UpdatesStorage)(nil)
+
+// UpdatesStorage implements gotd's `updates.StateStorage` and `updates.ChannelAccessHasher`
+// in the states, channels_pts and bots_entities tables.
+type UpdatesStorage struct {
+	db *sqlx.DB
+}
+
+func NewUpdatesStorage(db *sqlx.DB) *UpdatesStorage {
+	return &UpdatesStorage{db: db}
+}
+
+func (s *UpdatesStorage) GetState(ctx context.Context, userID int64) (updates.State, bool, error) {
+	state := State{}
+	err := s.db.GetContext(ctx, &state, "SELECT bot_user_id, pts, qts, date, seq FROM states WHERE bot_user_id=$1", userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return updates.State{}, false, nil
+	}
+	if err != nil {
+		return updates.State{}, false, err
+	}
+	return updates.State{
+		Pts:  int(state.Pts.Int32),
+		Qts:  int(state.Qts.Int32),
+		Date: int(state.Date.Int32),
+		Seq:  int(state.Seq.Int32),
+	}, true, nil
+}
+
+// SetState replaces the state, the channels are fetched again by gotd.
+func (s *UpdatesStorage) SetState(ctx context.Context, userID int64, state updates.State) error {
+	return WithTx(ctx, s.db, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, `
+            INSERT INTO
+              states (bot_user_id, pts, qts, date, seq)
+            VALUES
+              ($1, $2, $3, $4, $5)
+            ON CONFLICT (bot_user_id) DO UPDATE SET
+              pts = EXCLUDED.pts,
+              qts = EXCLUDED.qts,
+              date = EXCLUDED.date,
+              seq = EXCLUDED.seq;
+		`, userID, state.Pts, state.Qts, state.Date, state.Seq)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM channels_pts WHERE bot_user_id=$1", userID)
+		return err
+	})
+}
+
+func (s *UpdatesStorage) SetPts(ctx context.Context, userID int64, pts int) error {
+	return s.updateState(ctx, userID, "UPDATE states SET pts=$1 WHERE bot_user_id=$2", pts)
+}
+
+func (s *UpdatesStorage) SetQts(ctx context.Context, userID int64, qts int) error {
+	return s.updateState(ctx, userID, "UPDATE states SET qts=$1 WHERE bot_user_id=$2", qts)
+}
+
+func (s *UpdatesStorage) SetDate(ctx context.Context, userID int64, date int) error {
+	return s.updateState(ctx, userID, "UPDATE states SET date=$1 WHERE bot_user_id=$2", date)
+}
+
+func (s *UpdatesStorage) SetSeq(ctx context.Context, userID int64, seq int) error {
+	return s.updateState(ctx, userID, "UPDATE states SET seq=$1 WHERE bot_user_id=$2", seq)
+}
+
+func (s *UpdatesStorage) SetDateSeq(ctx context.Context, userID int64, date, seq int) error {
+	return s.updateState(ctx, userID, "UPDATE states SET date=$1, seq=$2 WHERE bot_user_id=$3", date, seq)
+}
+
+func (s *UpdatesStorage) GetChannelPts(ctx context.Context, userID, channelID int64) (int, bool, error) {
+	var pts int
+	err := s.db.GetContext(ctx, &pts, "SELECT pts FROM channels_pts WHERE bot_user_id=$1 AND channel_id=$2", userID, channelID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return pts, true, nil
+}
+
+func (s *UpdatesStorage) SetChannelPts(ctx context.Context, userID, channelID int64, pts int) error {
+	_, err := s.db.ExecContext(ctx, `
+            INSERT INTO
+              channels_pts (bot_user_id, channel_id, pts)
+            VALUES
+              ($1, $2, $3)
+            ON CONFLICT (bot_user_id, channel_id) DO UPDATE SET pts = EXCLUDED.pts;
+		`, userID, channelID, pts)
+	return err
+}
+
+func (s *UpdatesStorage) ForEachChannels(ctx context.Context, userID int64, f func(ctx context.Context, channelID int64, pts int) error) error {
+	var channels []ChannelsPts
+	err := s.db.SelectContext(ctx, &channels, "SELECT * FROM channels_pts WHERE bot_user_id=$1", userID)
+	if err != nil {
+		return err
+	}
+	for _, channel := range channels {
+		if err := f(ctx, channel.ChannelID, channel.Pts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetChannelAccessHash stores the access hash in bots_entities, the channel ID is marked
+// like TDLib does (-100...), so it doesn't collide with users.
+// The peer stored by the PeerStorage is cleared when the access hash changes, so it isn't resolved
+// with the old one.
+func (s *UpdatesStorage) SetChannelAccessHash(ctx context.Context, userID, channelID, accessHash int64) error {
+	_, err := s.db.ExecContext(ctx, `
+            INSERT INTO
+              bots_entities (entity_id_1, entity_id_2, hash)
+            VALUES
+              ($1, $2, $3)
+            ON CONFLICT (entity_id_1, entity_id_2) DO UPDATE SET
+              hash = EXCLUDED.hash,
+              peer = CASE WHEN bots_entities.hash = EXCLUDED.hash THEN bots_entities.peer ELSE NULL END;
+		`, userID, channelEntityID(channelID), accessHash)
+	return err
+}
+
+func (s *UpdatesStorage) GetChannelAccessHash(ctx context.Context, userID, channelID int64) (int64, bool, error) {
+	relationship := BotAndEntityRelationship{}
+	err := s.db.GetContext(ctx, &relationship, "SELECT * FROM bots_entities WHERE entity_id_1=$1 AND entity_id_2=$2", userID, channelEntityID(channelID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return relationship.Hash.Int64, relationship.Hash.Valid, nil
+}
+
+// updateState updates the state of the user, gotd expects an error if there is none.
+// The user ID is the last argument of the query.
+func (s *UpdatesStorage) updateState(ctx context.Context, userID int64, query string, args ...any) error {
+	result, err := s.db.ExecContext(ctx, query, append(args, userID)...)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("no updates state for user %d", userID)
+	}
+	return nil
+}
+
+func channelEntityID(channelID int64) int64 {
+	var id constant.TDLibPeerID
+	id.Channel(channelID)
+	return int64(id)
+}
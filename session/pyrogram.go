@@ -0,0 +1,141 @@
+package session
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/gotd/td/crypto"
+	"github.com/gotd/td/session"
+)
+
+// Sizes of the decoded Pyrogram string sessions.
+// See https://github.com/pyrogram/pyrogram/blob/master/pyrogram/storage/storage.py
+const (
+	// '>BI?256sQ?': DC ID, API ID, test mode, auth key, user ID, is bot.
+	pyrogramSessionSize = 271
+	// '>B?256sI?': DC ID, test mode, auth key, user ID, is bot.
+	pyrogramOldSessionSize = 263
+	// '>B?256sQ?': same as above, with a 64-bit user ID.
+	pyrogramOldSessionSize64 = 267
+)
+
+// PyrogramSession is a decoded Pyrogram string session.
+// Pyrogram does not store the datacenter address, so it is taken from the
+// built-in DC list.
+type PyrogramSession struct {
+	Data   *session.Data
+	APIID  int
+	UserID int64
+	IsBot  bool
+}
+
+// ParsePyrogramSession decodes a Pyrogram string session, in the current or
+// in one of the older layouts.
+func ParsePyrogramSession(sessionString string) (*PyrogramSession, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(sessionString, "="))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode pyrogram session: %w", err)
+	}
+
+	var (
+		dc       int
+		apiID    int
+		testMode bool
+		authKey  []byte
+		userID   int64
+		isBot    bool
+	)
+	switch len(data) {
+	case pyrogramSessionSize:
+		// | 1   | byte   | DC ID     |
+		// | 4   | uint32 | API ID    |
+		// | 1   | bool   | Test mode |
+		// | 256 | bytes  | Auth key  |
+		// | 8   | uint64 | User ID   |
+		// | 1   | bool   | Is bot    |
+		dc = int(data[0])
+		apiID = int(binary.BigEndian.Uint32(data[1:5]))
+		testMode = data[5] != 0
+		authKey = data[6:262]
+		userID = int64(binary.BigEndian.Uint64(data[262:270]))
+		isBot = data[270] != 0
+	case pyrogramOldSessionSize:
+		dc = int(data[0])
+		testMode = data[1] != 0
+		authKey = data[2:258]
+		userID = int64(binary.BigEndian.Uint32(data[258:262]))
+		isBot = data[262] != 0
+	case pyrogramOldSessionSize64:
+		dc = int(data[0])
+		testMode = data[1] != 0
+		authKey = data[2:258]
+		userID = int64(binary.BigEndian.Uint64(data[258:266]))
+		isBot = data[266] != 0
+	default:
+		return nil, fmt.Errorf("pyrogram session has invalid length: %d", len(data))
+	}
+
+	addr, err := getDCAddress(dc, testMode)
+	if err != nil {
+		return nil, err
+	}
+	var key crypto.Key
+	copy(key[:], authKey)
+	id := key.WithID().ID
+
+	return &PyrogramSession{
+		Data: &session.Data{
+			Config:    session.Config{TestMode: testMode, ThisDC: dc},
+			DC:        dc,
+			Addr:      addr,
+			AuthKey:   key[:],
+			AuthKeyID: id[:],
+		},
+		APIID:  apiID,
+		UserID: userID,
+		IsBot:  isBot,
+	}, nil
+}
+
+// EncodePyrogramSession encodes the session to a Pyrogram string session, using the
+// current '>BI?256sQ?' layout.
+// Pyrogram needs the API ID and the user ID, which are not part of the gotd session.
+func EncodePyrogramSession(sessionData *session.Data, apiID int, userID int64, isBot bool) (string, error) {
+	if len(sessionData.AuthKey) != 256 {
+		return "", fmt.Errorf("invalid auth key length: %d", len(sessionData.AuthKey))
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(pyrogramSessionSize)
+	// | 1   | byte   | DC ID     |
+	buf.WriteByte(byte(sessionData.DC))
+	// | 4   | uint32 | API ID    |
+	buf.Write(binary.BigEndian.AppendUint32(nil, uint32(apiID)))
+	// | 1   | bool   | Test mode |
+	buf.WriteByte(boolToByte(sessionData.Config.TestMode))
+	// | 256 | bytes  | Auth key  |
+	buf.Write(sessionData.AuthKey)
+	// | 8   | uint64 | User ID   |
+	buf.Write(binary.BigEndian.AppendUint64(nil, uint64(userID)))
+	// | 1   | bool   | Is bot    |
+	buf.WriteByte(boolToByte(isBot))
+
+	return base64.RawURLEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// isPyrogramSessionString reports whether the string has the length of a Pyrogram string session.
+// Telethon sessions are 353 or 369 characters long, so they never collide.
+func isPyrogramSessionString(sessionString string) bool {
+	n := base64.RawURLEncoding.DecodedLen(len(strings.TrimRight(sessionString, "=")))
+	return n == pyrogramSessionSize || n == pyrogramOldSessionSize || n == pyrogramOldSessionSize64
+}
+
+func boolToByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
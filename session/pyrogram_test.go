@@ -0,0 +1,78 @@
+package session
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"testing"
+
+	"github.com/gotd/td/session"
+)
+
+func testAuthKey() []byte {
+	key := make([]byte, 256)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	return key
+}
+
+func TestPyrogramSessionRoundTrip(t *testing.T) {
+	data := &session.Data{DC: 2, AuthKey: testAuthKey()}
+	sessionString, err := EncodePyrogramSession(data, 2040, 7513073974, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessionString) != 362 {
+		t.Fatalf("unexpected session string length: %d", len(sessionString))
+	}
+
+	decoded, err := ParsePyrogramSession(sessionString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Data.DC != 2 || decoded.APIID != 2040 || decoded.UserID != 7513073974 || decoded.IsBot {
+		t.Fatalf("unexpected session: %+v", decoded)
+	}
+	if !bytes.Equal(decoded.Data.AuthKey, data.AuthKey) {
+		t.Fatal("auth key mismatch")
+	}
+	if decoded.Data.Addr == "" {
+		t.Fatalf("unexpected address: %s", decoded.Data.Addr)
+	}
+
+	again, err := EncodePyrogramSession(decoded.Data, decoded.APIID, decoded.UserID, decoded.IsBot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != sessionString {
+		t.Fatal("session string changed after round-trip")
+	}
+
+	parsed, err := ParseSessionString(sessionString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.DC != 2 {
+		t.Fatalf("unexpected DC: %d", parsed.DC)
+	}
+}
+
+func TestParseOldPyrogramSession(t *testing.T) {
+	// '>B?256sI?'
+	var buf bytes.Buffer
+	buf.WriteByte(4)
+	buf.WriteByte(0)
+	buf.Write(testAuthKey())
+	buf.Write(binary.BigEndian.AppendUint32(nil, 123456))
+	buf.WriteByte(1)
+	sessionString := base64.URLEncoding.EncodeToString(buf.Bytes())
+
+	decoded, err := ParsePyrogramSession(sessionString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Data.DC != 4 || decoded.UserID != 123456 || !decoded.IsBot || decoded.APIID != 0 {
+		t.Fatalf("unexpected session: %+v", decoded)
+	}
+}
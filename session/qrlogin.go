@@ -0,0 +1,170 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/gotd/td/session"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/telegram/auth/qrlogin"
+	"github.com/gotd/td/telegram/dcs"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+	"go.uber.org/zap"
+	"rsc.io/qr"
+
+	"github.com/prdsrm/std/utils"
+)
+
+// PasswordProvider provides the 2FA password of the account, like DefaultAuthConversator.
+type PasswordProvider interface {
+	Password(ctx context.Context) (string, error)
+}
+
+// QRLoginOptions configures QRLogin.
+type QRLoginOptions struct {
+	Device telegram.DeviceConfig
+	// APIID and APIHash are the ones of Telegram Desktop by default.
+	APIID   int
+	APIHash string
+	Proxy   string
+	// Password is asked if the account has 2FA enabled, the login fails without it.
+	Password PasswordProvider
+	// Show is called with the login URL, each time a new one is exported since the previous
+	// one expired. By default, the URL is printed as a QR code to Out, os.Stdout by default.
+	Show func(ctx context.Context, loginURL string) error
+	Out  io.Writer
+	// DCList is the list of datacenters, production ones by default.
+	DCList dcs.List
+	Logger *zap.Logger
+}
+
+// QRLoginResult is the session created by QRLogin.
+type QRLoginResult struct {
+	// SessionString is the Telethon string session.
+	SessionString string
+	Data          *session.Data
+	// UserID is the ID of the account, which isn't stored in the Telethon string session, but
+	// needed by Pyrogram sessions and tdata folders.
+	UserID int64
+}
+
+// QRLogin logs in to a new session by scanning a QR code, from the Telegram app of an account
+// already logged in, in Settings > Devices > Link Desktop Device, and returns the new session.
+func QRLogin(ctx context.Context, opts QRLoginOptions) (*QRLoginResult, error) {
+	if opts.APIID == 0 {
+		opts.APIID, opts.APIHash = TdesktopApiID, TdesktopApiHash
+	}
+	show := opts.Show
+	if show == nil {
+		out := opts.Out
+		if out == nil {
+			out = os.Stdout
+		}
+		show = func(ctx context.Context, loginURL string) error {
+			fmt.Fprintln(out, "Scan the QR code in Settings > Devices > Link Desktop Device:")
+			return WriteQRCode(out, loginURL)
+		}
+	}
+	var resolver dcs.Resolver
+	if opts.Proxy != "" {
+		var err error
+		resolver, err = utils.NewResolver(opts.Proxy)
+		if err != nil {
+			return nil, err
+		}
+	}
+	storage := &MemorySession{}
+	// The login token update is received before the account is authorized, so it's handled
+	// by the dispatcher directly, without the updates manager of Connect.
+	dispatcher := tg.NewUpdateDispatcher()
+	loggedIn := qrlogin.OnLoginToken(dispatcher)
+	client := telegram.NewClient(opts.APIID, opts.APIHash, telegram.Options{
+		Resolver:       resolver,
+		SessionStorage: storage,
+		Device:         opts.Device,
+		DCList:         opts.DCList,
+		Logger:         opts.Logger,
+		UpdateHandler:  dispatcher,
+	})
+	var userID int64
+	err := client.Run(ctx, func(ctx context.Context) error {
+		// The QR helper of the client migrates to the DC of the account when needed.
+		_, err := client.QR().Auth(ctx, loggedIn, func(ctx context.Context, token qrlogin.Token) error {
+			return show(ctx, token.URL())
+		})
+		if tgerr.Is(err, "SESSION_PASSWORD_NEEDED") {
+			if opts.Password == nil {
+				return fmt.Errorf("2FA password is required: %w", err)
+			}
+			password, err := opts.Password.Password(ctx)
+			if err != nil {
+				return err
+			}
+			_, err = client.Auth().Password(ctx, password)
+			if err != nil {
+				return fmt.Errorf("could not authenticate: %w", err)
+			}
+		} else if err != nil {
+			return fmt.Errorf("could not authenticate: %w", err)
+		}
+		self, err := client.Self(ctx)
+		if err != nil {
+			return err
+		}
+		userID = self.ID
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	loader := session.Loader{Storage: storage}
+	data, err := loader.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sessionString, err := EncodeSessionToTelethonString(data)
+	if err != nil {
+		return nil, err
+	}
+	return &QRLoginResult{SessionString: sessionString, Data: data, UserID: userID}, nil
+}
+
+// WriteQRCode writes the text as a QR code, drawn with blocks for terminals, two modules per
+// line. Light modules are drawn, so the code can be scanned on dark terminals.
+func WriteQRCode(w io.Writer, text string) error {
+	code, err := qr.Encode(text, qr.L)
+	if err != nil {
+		return err
+	}
+	// Scanners need a light border around the code.
+	const quietZone = 2
+	light := func(x, y int) bool {
+		if x < 0 || y < 0 || x >= code.Size || y >= code.Size {
+			return true
+		}
+		return !code.Black(x, y)
+	}
+	var b strings.Builder
+	for y := -quietZone; y < code.Size+quietZone; y += 2 {
+		for x := -quietZone; x < code.Size+quietZone; x++ {
+			top, bottom := light(x, y), light(x, y+1)
+			switch {
+			case top && bottom:
+				b.WriteRune('█')
+			case top:
+				b.WriteRune('▀')
+			case bottom:
+				b.WriteRune('▄')
+			default:
+				b.WriteRune(' ')
+			}
+		}
+		b.WriteRune('\n')
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,39 @@
+package session
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"rsc.io/qr"
+)
+
+func TestWriteQRCode(t *testing.T) {
+	const text = "tg://login?token=AQIDBA=="
+	var b strings.Builder
+	if err := WriteQRCode(&b, text); err != nil {
+		t.Fatal(err)
+	}
+	code, err := qr.Encode(text, qr.L)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	size := code.Size + 4
+	if len(lines) != (size+1)/2 {
+		t.Fatalf("unexpected number of lines: %d", len(lines))
+	}
+	for i, line := range lines {
+		if utf8.RuneCountInString(line) != size {
+			t.Fatalf("unexpected width of line %d: %d", i, utf8.RuneCountInString(line))
+		}
+	}
+	// The quiet zone is light.
+	if strings.Trim(lines[0], "█") != "" {
+		t.Fatalf("first line isn't light: %q", lines[0])
+	}
+	// The finder pattern, a dark ring, starts under the quiet zone.
+	if !strings.HasPrefix(lines[1], "██ ▄▄▄▄▄ ") {
+		t.Fatalf("unexpected finder pattern: %q", lines[1])
+	}
+}
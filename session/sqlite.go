@@ -0,0 +1,119 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/gotd/td/session"
+	"github.com/jmoiron/sqlx"
+)
+
+// telethonSQLiteVersion is the version of the Telethon SQLite session schema.
+// See https://github.com/LonamiWebs/Telethon/blob/v1/telethon/sessions/sqlite.py
+const telethonSQLiteVersion = 7
+
+var telethonSQLiteSchema = []string{
+	`CREATE TABLE version (version integer primary key)`,
+	`CREATE TABLE sessions (
+		dc_id integer primary key,
+		server_address text,
+		port integer,
+		auth_key blob,
+		takeout_id integer
+	)`,
+	`CREATE TABLE entities (
+		id integer primary key,
+		hash integer not null,
+		username text,
+		phone integer,
+		name text,
+		date integer
+	)`,
+	`CREATE TABLE sent_files (
+		md5_digest blob,
+		file_size integer,
+		type integer,
+		id integer,
+		hash integer,
+		primary key(md5_digest, file_size, type)
+	)`,
+	`CREATE TABLE update_state (
+		id integer primary key,
+		pts integer,
+		qts integer,
+		date integer,
+		seq integer
+	)`,
+}
+
+// WriteTelethonSQLiteSession creates a Telethon SQLite session file, which can be used by
+// Telethon with `TelegramClient(path, ...)`.
+// It doesn't overwrite an existing file.
+func WriteTelethonSQLiteSession(sessionPath string, sessionData *session.Data) error {
+	if len(sessionData.AuthKey) != 256 {
+		return fmt.Errorf("invalid auth key length: %d", len(sessionData.AuthKey))
+	}
+	addr := sessionData.Addr
+	if addr == "" {
+		var err error
+		addr, err = getDCAddress(sessionData.DC, sessionData.Config.TestMode)
+		if err != nil {
+			return err
+		}
+	}
+	ipAddr, port, err := splitDCAddress(addr, sessionData.Config)
+	if err != nil {
+		return err
+	}
+
+	// Created exclusively, so an existing file is never overwritten, even by a concurrent write.
+	file, err := os.OpenFile(sessionPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o600)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("session file %s already exists", sessionPath)
+	}
+	if err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(sessionPath)
+		return err
+	}
+	// The file is removed when the session couldn't be written, so the write can be retried.
+	if err := writeTelethonSQLiteSession(sessionPath, sessionData.DC, ipAddr, port, sessionData.AuthKey); err != nil {
+		os.Remove(sessionPath)
+		return err
+	}
+	return nil
+}
+
+// writeTelethonSQLiteSession writes the Telethon schema and the session in the empty database.
+func writeTelethonSQLiteSession(sessionPath string, dc int, ipAddr string, port int, authKey []byte) error {
+	db, err := sqlx.Connect("sqlite3", sessionPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, query := range telethonSQLiteSchema {
+		if _, err := tx.Exec(query); err != nil {
+			return fmt.Errorf("couldn't create telethon schema: %w", err)
+		}
+	}
+	if _, err := tx.Exec("INSERT INTO version VALUES (?)", telethonSQLiteVersion); err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"INSERT INTO sessions (dc_id, server_address, port, auth_key, takeout_id) VALUES (?, ?, ?, ?, NULL)",
+		dc, ipAddr, port, authKey,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,197 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gotd/td/session"
+	"golang.org/x/crypto/scrypt"
+)
+
+// FileSession implements session storage in a plain file, so the session updated by gotd
+// (auth key, DC migration) is kept between restarts.
+// Goroutine-safe.
+type FileSession struct {
+	Path string
+	mux  sync.Mutex
+}
+
+// LoadSession loads session from file.
+func (s *FileSession) LoadSession(context.Context) ([]byte, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) || len(data) == 0 {
+		return nil, session.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// StoreSession stores session to file.
+func (s *FileSession) StoreSession(ctx context.Context, data []byte) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return writeFileAtomic(s.Path, data)
+}
+
+// Encrypted session files layout.
+const (
+	encryptedSessionMagic = "STDS"
+	encryptedSessionSalt  = 16
+	// scrypt parameters recommended for interactive logins.
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+var WrongPassphraseError = errors.New("couldn't decrypt session, wrong passphrase")
+
+// EncryptedFileSession implements session storage in a file encrypted with AES-GCM, the key is
+// derived from a passphrase with scrypt.
+// Goroutine-safe.
+type EncryptedFileSession struct {
+	Path       string
+	passphrase []byte
+	mux        sync.Mutex
+	// Key derived for salt, cached because scrypt is slow on purpose.
+	salt []byte
+	key  []byte
+}
+
+// NewEncryptedFileSession creates a new encrypted session storage, at the given path.
+func NewEncryptedFileSession(path string, passphrase string) (*EncryptedFileSession, error) {
+	if passphrase == "" {
+		return nil, errors.New("passphrase is empty")
+	}
+	return &EncryptedFileSession{Path: path, passphrase: []byte(passphrase)}, nil
+}
+
+// NewEncryptedFileSessionFromEnv creates a new encrypted session storage, with the passphrase
+// read from the given environment variable.
+func NewEncryptedFileSessionFromEnv(path string, env string) (*EncryptedFileSession, error) {
+	passphrase, exists := os.LookupEnv(env)
+	if !exists {
+		return nil, fmt.Errorf("%s is not set", env)
+	}
+	return NewEncryptedFileSession(path, passphrase)
+}
+
+// LoadSession loads and decrypts session from file.
+func (s *EncryptedFileSession) LoadSession(context.Context) ([]byte, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	content, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) || len(content) == 0 {
+		return nil, session.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// | 4  | bytes | Magic      |
+	// | 16 | bytes | Salt       |
+	// | 12 | bytes | Nonce      |
+	// | n  | bytes | Ciphertext |
+	if !bytes.HasPrefix(content, []byte(encryptedSessionMagic)) {
+		return nil, fmt.Errorf("%s is not an encrypted session file", s.Path)
+	}
+	content = content[len(encryptedSessionMagic):]
+	if len(content) < encryptedSessionSalt {
+		return nil, fmt.Errorf("%s is truncated", s.Path)
+	}
+	aead, err := s.aead(content[:encryptedSessionSalt])
+	if err != nil {
+		return nil, err
+	}
+	content = content[encryptedSessionSalt:]
+	if len(content) < aead.NonceSize() {
+		return nil, fmt.Errorf("%s is truncated", s.Path)
+	}
+	data, err := aead.Open(nil, content[:aead.NonceSize()], content[aead.NonceSize():], []byte(encryptedSessionMagic))
+	if err != nil {
+		return nil, WrongPassphraseError
+	}
+	return data, nil
+}
+
+// StoreSession encrypts and stores session to file.
+// A new nonce is used every time, the salt is kept once the file exists.
+func (s *EncryptedFileSession) StoreSession(ctx context.Context, data []byte) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	salt := s.salt
+	if salt == nil {
+		salt = make([]byte, encryptedSessionSalt)
+		if _, err := rand.Read(salt); err != nil {
+			return err
+		}
+	}
+	aead, err := s.aead(salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(encryptedSessionMagic)
+	buf.Write(salt)
+	buf.Write(nonce)
+	buf.Write(aead.Seal(nil, nonce, data, []byte(encryptedSessionMagic)))
+	return writeFileAtomic(s.Path, buf.Bytes())
+}
+
+// aead returns the AES-GCM cipher for the given salt.
+func (s *EncryptedFileSession) aead(salt []byte) (cipher.AEAD, error) {
+	if s.key == nil || !bytes.Equal(s.salt, salt) {
+		key, err := scrypt.Key(s.passphrase, salt, scryptN, scryptR, scryptP, 32)
+		if err != nil {
+			return nil, err
+		}
+		s.salt = append([]byte(nil), salt...)
+		s.key = key
+	}
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// writeFileAtomic writes the file next to its destination first, so a crash never leaves
+// a truncated session behind.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,56 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gotd/td/session"
+)
+
+func TestFileSessions(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	encrypted, err := NewEncryptedFileSession(filepath.Join(dir, "encrypted.session"), "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, storage := range []session.Storage{
+		&FileSession{Path: filepath.Join(dir, "plain.session")},
+		encrypted,
+	} {
+		if _, err := storage.LoadSession(ctx); !errors.Is(err, session.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+		for _, data := range [][]byte{[]byte(`{"Version":1}`), []byte(`{"Version":1,"Data":{}}`)} {
+			if err := storage.StoreSession(ctx, data); err != nil {
+				t.Fatal(err)
+			}
+			loaded, err := storage.LoadSession(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(loaded, data) {
+				t.Fatalf("loaded %s instead of %s", loaded, data)
+			}
+		}
+	}
+
+	content, err := os.ReadFile(encrypted.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(content, []byte("Version")) {
+		t.Fatal("session is stored in plain text")
+	}
+	wrong, err := NewEncryptedFileSession(encrypted.Path, "wrong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wrong.LoadSession(ctx); !errors.Is(err, WrongPassphraseError) {
+		t.Fatalf("expected WrongPassphraseError, got %v", err)
+	}
+}
@@ -5,12 +5,12 @@ import (
 	"database/sql"
 	"encoding/base64"
 	"encoding/binary"
-	"errors"
 	"fmt"
 	"net"
 	"strconv"
 	"strings"
 
+	"github.com/gotd/td/crypto"
 	"github.com/gotd/td/session"
 	"github.com/gotd/td/session/tdesktop"
 	"github.com/gotd/td/telegram/dcs"
@@ -34,6 +34,7 @@ func ConvertSQLiteSessionToTelethonStringSession(
 	if err != nil {
 		return "", err
 	}
+	defer db.Close()
 
 	var session SQLiteSession
 	err = db.Get(&session, "SELECT * FROM sessions")
@@ -48,117 +49,239 @@ func ConvertSQLiteSessionToTelethonStringSession(
 	return stringSession, nil
 }
 
-func ConvertTDATAToTelethonStringSession(dirname string) (string, error) {
-	accounts, err := tdesktop.Read(dirname, nil)
+// TDATAAccount is an account found in a Telegram Desktop tdata folder.
+type TDATAAccount struct {
+	UserID        int64
+	DC            int
+	SessionString string
+}
+
+// ConvertTDATAToTelethonStringSessions converts every account of a Telegram Desktop tdata
+// folder to a Telethon string session.
+// The passcode is the local passcode set in Telegram Desktop, leave it empty if there is none.
+func ConvertTDATAToTelethonStringSessions(dirname string, passcode string) ([]TDATAAccount, error) {
+	var key []byte
+	if passcode != "" {
+		key = []byte(passcode)
+	}
+	accounts, err := tdesktop.Read(dirname, key)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	var result []TDATAAccount
 	for _, account := range accounts {
 		sd, err := session.TDesktopSession(account)
 		if err != nil {
-			return "", err
+			return nil, fmt.Errorf("couldn't read account %d: %w", account.Authorization.UserID, err)
 		}
-		addr := strings.Split(sd.Addr, " ")
-		ipAddr := addr[0]
-		port, err := strconv.Atoi(addr[1])
+		stringSession, err := EncodeSessionToTelethonString(sd)
 		if err != nil {
-			return "", err
+			return nil, fmt.Errorf("couldn't encode account %d: %w", account.Authorization.UserID, err)
 		}
-		stringSession, err := GetSessionString(sd.DC, ipAddr, port, sd.AuthKey)
-		return stringSession, err
+		result = append(result, TDATAAccount{
+			UserID:        int64(account.Authorization.UserID),
+			DC:            sd.DC,
+			SessionString: stringSession,
+		})
 	}
-	return "", errors.New("too many accounts")
+	return result, nil
 }
 
-func GetSessionString(dc int, addr string, port int, authkey []byte) (string, error) {
-	// Given parameter should contain version + data
-	// where data encoded using pack as '>B4sH256s' or '>B16sH256s'
-	// depending on IP type.
-	// Some explanation about struct.pack: https://docs.python.org/3/library/struct.html#byte-order-size-and-alignment
-	// '>': means big-endian
-	// 'B': unsigned char.
-	// '4s' or '16s': char[], so bytes. Can be 4 characters long or 16.
-	// 'H': unsigned short, so a uint small integer(16bit): uint16.
-	// '256s': char[], 256 characters.
-	var buf bytes.Buffer
-	// | 1    | byte   | DC ID       |
-	err := buf.WriteByte(byte(dc))
+// ConvertTDATAToTelethonStringSession converts the first account of a Telegram Desktop tdata
+// folder to a Telethon string session.
+// Use ConvertTDATAToTelethonStringSessions to get all of them.
+func ConvertTDATAToTelethonStringSession(dirname string) (string, error) {
+	accounts, err := ConvertTDATAToTelethonStringSessions(dirname, "")
 	if err != nil {
 		return "", err
 	}
+	if len(accounts) == 0 {
+		return "", tdesktop.ErrNoAccounts
+	}
+	return accounts[0].SessionString, nil
+}
 
-	// | 4/16 | bytes  | IP address  |
+// ReadTDATAAccount reads an account of a Telegram Desktop tdata folder.
+// The passcode is the local passcode set in Telegram Desktop, leave it empty if there is none.
+// The user ID selects the account when the folder contains several of them, leave it 0 otherwise:
+// a MultipleAccountsError listing them is returned.
+func ReadTDATAAccount(dirname string, passcode string, userID int64) (*TDATASession, error) {
+	var key []byte
+	if passcode != "" {
+		key = []byte(passcode)
+	}
+	accounts, err := tdesktop.Read(dirname, key)
+	if err != nil {
+		return nil, err
+	}
+	if userID == 0 && len(accounts) == 1 {
+		userID = int64(accounts[0].Authorization.UserID)
+	}
+	userIDs := make([]string, 0, len(accounts))
+	for _, account := range accounts {
+		if int64(account.Authorization.UserID) != userID {
+			userIDs = append(userIDs, strconv.FormatUint(account.Authorization.UserID, 10))
+			continue
+		}
+		sd, err := session.TDesktopSession(account)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read account %d: %w", userID, err)
+		}
+		return &TDATASession{UserID: userID, Data: sd}, nil
+	}
+	if userID == 0 {
+		return nil, fmt.Errorf("%w: %s", MultipleAccountsError, strings.Join(userIDs, ", "))
+	}
+	return nil, fmt.Errorf("account %d not found, the folder contains %s", userID, strings.Join(userIDs, ", "))
+}
+
+// Given parameter should contain version + data
+// where data encoded using pack as '>B4sH256s' or '>B16sH256s'
+// depending on IP type.
+// Some explanation about struct.pack: https://docs.python.org/3/library/struct.html#byte-order-size-and-alignment
+// '>': means big-endian
+// 'B': unsigned char.
+// '4s' or '16s': char[], so bytes. Can be 4 characters long or 16.
+// 'H': unsigned short, so a uint small integer(16bit): uint16.
+// '256s': char[], 256 characters.
+const (
+	latestTelethonVersion = '1'
+	telethonSessionSize   = 263
+	telethonSessionSize6  = 275
+)
+
+// GetSessionString encodes the session to a Telethon string session.
+// The address must be an IPv4 or an IPv6 address, Telethon doesn't support hostnames.
+func GetSessionString(dc int, addr string, port int, authkey []byte) (string, error) {
 	ip := net.ParseIP(addr)
-	_, err = buf.Write(ip.To4())
-	// | 2    | uint16 | Port        |
-	portBytes := make([]byte, 2)
-	binary.BigEndian.PutUint16(portBytes, uint16(port))
-	_, err = buf.Write(portBytes)
+	if ip == nil {
+		return "", fmt.Errorf("invalid datacenter IP address %q", addr)
+	}
+	// Use the 4 bytes form for IPv4 (and IPv4-mapped IPv6) addresses, like Telethon does.
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if port <= 0 || port > 65535 {
+		return "", fmt.Errorf("invalid datacenter port %d", port)
+	}
+	if len(authkey) != 256 {
+		return "", fmt.Errorf("invalid auth key length: %d", len(authkey))
+	}
 
+	var buf bytes.Buffer
+	// | 1    | byte   | DC ID       |
+	buf.WriteByte(byte(dc))
+	// | 4/16 | bytes  | IP address  |
+	buf.Write(ip)
+	// | 2    | uint16 | Port        |
+	buf.Write(binary.BigEndian.AppendUint16(nil, uint16(port)))
 	// | 256  | bytes  | Auth key    |
-	_, err = buf.Write(authkey[:])
+	buf.Write(authkey)
+
+	return string(latestTelethonVersion) + base64.URLEncoding.EncodeToString(buf.Bytes()), nil
+}
 
+// EncodeSessionToTelethonString encodes the session to a Telethon string session.
+// If the session doesn't have an address, the one from the built-in DC list is used.
+func EncodeSessionToTelethonString(sessionData *session.Data) (string, error) {
+	addr := sessionData.Addr
+	if addr == "" {
+		var err error
+		addr, err = getDCAddress(sessionData.DC, sessionData.Config.TestMode)
+		if err != nil {
+			return "", err
+		}
+	}
+	ipAddr, port, err := splitDCAddress(addr, sessionData.Config)
 	if err != nil {
-		return "", fmt.Errorf("Couldn't pack bytes to buffer: %s", err)
+		return "", err
 	}
-
-	data := buf.Bytes()
-	stringSession := base64.URLEncoding.EncodeToString(data)
-	stringSession = fmt.Sprintf("%d%s", 1, stringSession)
-	return stringSession, err
+	return GetSessionString(sessionData.DC, ipAddr, port, sessionData.AuthKey)
 }
 
-func EncodeSessionToTelethonString(sessionData *session.Data) (string, error) {
-	// Given parameter should contain version + data
-	// where data encoded using pack as '>B4sH256s' or '>B16sH256s'
-	// depending on IP type.
-	// Some explaation about struct.pack: https://docs.python.org/3/library/struct.html#byte-order-size-and-alignment
-	// '>': means big-endian
-	// 'B': unsigned char.
-	// '4s' or '16s': char[], so bytes. Can be 4 characters long or 16.
-	// 'H': unsigned short, so a uint small integer(16bit): uint16.
-	// '256s': char[], 256 characters.
-	var buf bytes.Buffer
-	// | 1    | byte   | DC ID       |
-	err := buf.WriteByte(byte(sessionData.DC))
+// ParseTelethonSession decodes a Telethon string session, with an IPv4 or an IPv6 address.
+func ParseTelethonSession(sessionString string) (*session.Data, error) {
+	if len(sessionString) < 1 {
+		return nil, fmt.Errorf("given string too small: %d", len(sessionString))
+	}
+	if sessionString[0] != latestTelethonVersion {
+		return nil, fmt.Errorf("unexpected version %q, latest supported is %q", sessionString[0], latestTelethonVersion)
+	}
+	data, err := base64.URLEncoding.DecodeString(sessionString[1:])
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode telethon session: %w", err)
+	}
 
-	// | 4/16 | bytes  | IP address  |
-	var ipAddr string
-	var port int
-	if sessionData.Addr != "" {
-		addr := strings.Split(sessionData.Addr, ":")
-		if len(addr) != 2 {
-			return "", fmt.Errorf("Invalid datacenter address.")
-		}
-		ipAddr = addr[0]
-		port, _ = strconv.Atoi(addr[1])
-	} else {
-		list := dcs.Prod()
-		for _, option := range list.Options {
-			if sessionData.DC == option.ID && !option.Ipv6 {
-				ipAddr = option.IPAddress
-				port = option.Port
-				break
-			}
-		}
+	var ipLength int
+	switch len(data) {
+	case telethonSessionSize:
+		ipLength = net.IPv4len
+	case telethonSessionSize6:
+		ipLength = net.IPv6len
+	default:
+		return nil, fmt.Errorf("telethon session has invalid length: %d", len(data))
 	}
-	ip := net.ParseIP(ipAddr)
-	_, err = buf.Write(ip.To4())
+	// | 1    | byte   | DC ID       |
+	dc := int(data[0])
+	// | 4/16 | bytes  | IP address  |
+	ip := net.IP(data[1 : 1+ipLength])
 	// | 2    | uint16 | Port        |
-	portBytes := make([]byte, 2)                        // Create a 2-byte array for the port
-	binary.BigEndian.PutUint16(portBytes, uint16(port)) // Pack the port into the byte array
-	_, err = buf.Write(portBytes)
-
+	port := binary.BigEndian.Uint16(data[1+ipLength : 3+ipLength])
 	// | 256  | bytes  | Auth key    |
-	_, err = buf.Write(sessionData.AuthKey[:])
+	var key crypto.Key
+	copy(key[:], data[3+ipLength:])
+	id := key.WithID().ID
 
+	return &session.Data{
+		DC:        dc,
+		Addr:      net.JoinHostPort(ip.String(), strconv.Itoa(int(port))),
+		AuthKey:   key[:],
+		AuthKeyID: id[:],
+	}, nil
+}
+
+// splitDCAddress splits the datacenter address into its IP and its port.
+// Sessions imported from TDATA only contain the IP, so the port is taken from the config
+// when possible.
+func splitDCAddress(addr string, config session.Config) (string, int, error) {
+	if ip := net.ParseIP(addr); ip != nil {
+		for _, option := range config.DCOptions {
+			if net.ParseIP(option.IPAddress).Equal(ip) {
+				return addr, option.Port, nil
+			}
+		}
+		return addr, 443, nil
+	}
+	host, portString, err := net.SplitHostPort(addr)
 	if err != nil {
-		return "", fmt.Errorf("Couldn't pack bytes to buffer: %s", err)
+		return "", 0, fmt.Errorf("invalid datacenter address %q: %w", addr, err)
 	}
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid datacenter port %q: %w", portString, err)
+	}
+	return host, port, nil
+}
 
-	data := buf.Bytes()
-	stringSession := base64.URLEncoding.EncodeToString(data)
-	final := fmt.Sprintf("%d%s", 1, stringSession)
+// ParseSessionString decodes a Telethon, Pyrogram, GramJS or gotd JSON string session.
+func ParseSessionString(sessionString string) (*session.Data, error) {
+	format, err := detectString(sessionString)
+	if err != nil {
+		return nil, err
+	}
+	return parseString(strings.TrimSpace(sessionString), format)
+}
 
-	return final, nil
+// getDCAddress returns the address of the given datacenter, from the built-in DC list.
+func getDCAddress(dc int, test bool) (string, error) {
+	list := dcs.Prod()
+	if test {
+		list = dcs.Test()
+	}
+	for _, option := range dcs.FindPrimaryDCs(list.Options, dc, false) {
+		if !option.Ipv6 {
+			return net.JoinHostPort(option.IPAddress, strconv.Itoa(option.Port)), nil
+		}
+	}
+	return "", fmt.Errorf("can't find address for DC %d", dc)
 }
@@ -0,0 +1,193 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gotd/td/crypto"
+	"github.com/gotd/td/session"
+	"github.com/gotd/td/session/tdesktop"
+)
+
+func TestTelethonSessionRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		addr   string
+		length int
+	}{
+		{name: "IPv4", addr: "149.154.167.51:443", length: 353},
+		{name: "IPv6", addr: "[2001:67c:4e8:f002::a]:443", length: 369},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			data := &session.Data{DC: 2, Addr: test.addr, AuthKey: testAuthKey()}
+			sessionString, err := EncodeSessionToTelethonString(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sessionString) != test.length {
+				t.Fatalf("unexpected session string length: %d", len(sessionString))
+			}
+
+			decoded, err := ParseTelethonSession(sessionString)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decoded.DC != 2 || decoded.Addr != test.addr || !bytes.Equal(decoded.AuthKey, data.AuthKey) {
+				t.Fatalf("unexpected session: DC %d, address %s", decoded.DC, decoded.Addr)
+			}
+
+			// Make sure gotd reads it the same way.
+			expected, err := session.TelethonSession(sessionString)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected.Addr != decoded.Addr || !bytes.Equal(expected.AuthKeyID, decoded.AuthKeyID) {
+				t.Fatalf("gotd decoded %s, we decoded %s", expected.Addr, decoded.Addr)
+			}
+		})
+	}
+}
+
+func TestEncodeTelethonSessionErrors(t *testing.T) {
+	for _, data := range []*session.Data{
+		{DC: 2, Addr: "venus.web.telegram.org:443", AuthKey: testAuthKey()},
+		{DC: 2, Addr: "149.154.167.51:443", AuthKey: []byte{1, 2, 3}},
+		{DC: 2, Addr: "149.154.167.51:port", AuthKey: testAuthKey()},
+	} {
+		if _, err := EncodeSessionToTelethonString(data); err == nil {
+			t.Fatalf("expected an error for %s", data.Addr)
+		}
+	}
+}
+
+func TestEncodeTelethonSessionWithoutPort(t *testing.T) {
+	// Sessions imported from TDATA only have the IP address.
+	data := &session.Data{DC: 2, Addr: "149.154.167.51", AuthKey: testAuthKey()}
+	sessionString, err := EncodeSessionToTelethonString(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := ParseTelethonSession(sessionString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Addr != "149.154.167.51:443" {
+		t.Fatalf("unexpected address: %s", decoded.Addr)
+	}
+}
+
+func TestTelethonSQLiteSessionFailedWrite(t *testing.T) {
+	sessionPath := filepath.Join(t.TempDir(), "account.session")
+	data := &session.Data{DC: 4, Addr: "149.154.167.91:443", AuthKey: testAuthKey()}
+	schema := telethonSQLiteSchema
+	telethonSQLiteSchema = append(schema[:len(schema):len(schema)], "CREATE TABLE version (version integer primary key)")
+	err := WriteTelethonSQLiteSession(sessionPath, data)
+	telethonSQLiteSchema = schema
+	if err == nil {
+		t.Fatal("expected an error with an invalid schema")
+	}
+	// No partial file is left, so the write can be retried.
+	if _, err := os.Stat(sessionPath); !os.IsNotExist(err) {
+		t.Fatalf("session file left after a failed write: %v", err)
+	}
+	if err := WriteTelethonSQLiteSession(sessionPath, data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTelethonSQLiteSessionRoundTrip(t *testing.T) {
+	sessionPath := filepath.Join(t.TempDir(), "account.session")
+	data := &session.Data{DC: 4, Addr: "149.154.167.91:443", AuthKey: testAuthKey()}
+	if err := WriteTelethonSQLiteSession(sessionPath, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteTelethonSQLiteSession(sessionPath, data); err == nil {
+		t.Fatal("expected an error when the session file already exists")
+	}
+
+	sessionString, err := ConvertSQLiteSessionToTelethonStringSession(sessionPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := EncodeSessionToTelethonString(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sessionString != expected {
+		t.Fatal("session string mismatch")
+	}
+}
+
+func TestConvertTDATAToTelethonStringSessions(t *testing.T) {
+	dirname := t.TempDir()
+	sessions := []TDATASession{
+		{UserID: 7513073974, Data: &session.Data{DC: 2, AuthKey: testAuthKey()}},
+		{UserID: 123456, Data: &session.Data{DC: 4, AuthKey: testAuthKey()}},
+		{UserID: 654321, Data: &session.Data{DC: 5, AuthKey: testAuthKey()}},
+	}
+	if err := WriteTDATA(dirname, "", sessions...); err != nil {
+		t.Fatal(err)
+	}
+
+	accounts, err := ConvertTDATAToTelethonStringSessions(dirname, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != len(sessions) {
+		t.Fatalf("unexpected number of accounts: %d", len(accounts))
+	}
+	for i, account := range accounts {
+		if account.UserID != sessions[i].UserID || account.DC != sessions[i].Data.DC {
+			t.Fatalf("unexpected account %d: %+v", i, account)
+		}
+		data, err := ParseTelethonSession(account.SessionString)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data.DC != sessions[i].Data.DC {
+			t.Fatalf("unexpected DC for account %d: %d", i, data.DC)
+		}
+	}
+	// The first account is the one converted by ConvertTDATAToTelethonStringSession.
+	first, err := ConvertTDATAToTelethonStringSession(dirname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != accounts[0].SessionString {
+		t.Fatal("first account mismatch")
+	}
+
+	empty := t.TempDir()
+	writeTDATAWithoutAccounts(t, empty)
+	if _, err := ConvertTDATAToTelethonStringSessions(empty, ""); !errors.Is(err, tdesktop.ErrNoAccounts) {
+		t.Fatalf("expected ErrNoAccounts, got %v", err)
+	}
+	if _, err := ConvertTDATAToTelethonStringSession(empty); !errors.Is(err, tdesktop.ErrNoAccounts) {
+		t.Fatalf("expected ErrNoAccounts, got %v", err)
+	}
+}
+
+// writeTDATAWithoutAccounts writes a tdata folder whose key_datas file lists no account.
+func writeTDATAWithoutAccounts(t *testing.T, dirname string) {
+	var localKey crypto.Key
+	salt := make([]byte, tdesktopSaltSize)
+	var keyData bytes.Buffer
+	writeTDesktopArray(&keyData, salt)
+	encryptedKey, err := encryptTDesktopLocal(localKey[:], createTDesktopLocalKey(nil, salt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTDesktopArray(&keyData, encryptedKey)
+	// No account, and the active account.
+	encryptedInfo, err := encryptTDesktopLocal(make([]byte, 8), localKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTDesktopArray(&keyData, encryptedInfo)
+	if err := writeTDesktopFile(filepath.Join(dirname, "key_datas"), keyData.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,399 @@
+package session
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/gotd/ige"
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/crypto"
+	"github.com/gotd/td/session"
+	"github.com/gotd/td/telegram/dcs"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Telegram Desktop storage format, the reader lives in `github.com/gotd/td/session/tdesktop`.
+// See https://github.com/telegramdesktop/tdesktop/blob/dev/Telegram/SourceFiles/storage/storage_domain.cpp
+const (
+	// Version written in the header of every file, Telegram Desktop 4.0.0.
+	tdesktopFileVersion = 4000000
+	// Telegram Desktop allows 3 accounts without premium.
+	tdesktopMaxAccounts    = 3
+	tdesktopSaltSize       = 32
+	tdesktopIterationCount = 100000
+	tdesktopWideIDsTag     = ^uint64(0)
+	// The settings file is encrypted with a key derived from an empty passcode, in 4 iterations.
+	tdesktopSettingsIterationCount = 4
+	// Versions of MTP::Config and MTP::DcOptions serializations.
+	tdesktopConfigVersion    = 1
+	tdesktopDCOptionsVersion = 2
+)
+
+// Blocks of the settings and MTP data files, from storage_settings_scheme.h.
+const (
+	dbiAutoStart                = 0x06
+	dbiStartMinimized           = 0x07
+	dbiSeenTrayTooltip          = 0x0a
+	dbiAutoUpdate               = 0x0c
+	dbiLastUpdateCheck          = 0x0d
+	dbiSendToMenu               = 0x1d
+	dbiMtpAuthorization         = 0x4b
+	dbiAnimationsDisabled       = 0x57
+	dbiScalePercent             = 0x58
+	dbiFallbackProductionConfig = 0x60
+)
+
+var tdesktopFileMagic = []byte("TDF$")
+
+// TDATASession is a session to write in a tdata folder.
+// Telegram Desktop needs the user ID, which isn't part of the gotd session.
+type TDATASession struct {
+	UserID int64
+	Data   *session.Data
+}
+
+// WriteTDATA creates a Telegram Desktop tdata folder containing the given sessions.
+// The passcode is the local passcode asked by Telegram Desktop on startup, leave it empty if you
+// don't want one.
+//
+// It writes the settings file with the fallback production config, and for each account its
+// MTProto authorization, its MTProto config and an empty map, with the default values of Telegram
+// Desktop's formats. The folder is read back by ConvertTDATAToTelethonStringSessions and gotd's
+// tdesktop package, but it isn't guaranteed to load in Telegram Desktop.
+func WriteTDATA(dirname string, passcode string, sessions ...TDATASession) error {
+	if len(sessions) == 0 {
+		return errors.New("no session to write")
+	}
+	if len(sessions) > tdesktopMaxAccounts {
+		return fmt.Errorf("too many sessions: %d, Telegram Desktop supports up to %d", len(sessions), tdesktopMaxAccounts)
+	}
+	for _, s := range sessions {
+		if len(s.Data.AuthKey) != 256 {
+			return fmt.Errorf("invalid auth key length for user %d: %d", s.UserID, len(s.Data.AuthKey))
+		}
+	}
+	if err := os.MkdirAll(dirname, 0o700); err != nil {
+		return err
+	}
+
+	var localKey crypto.Key
+	salt := make([]byte, tdesktopSaltSize)
+	if _, err := rand.Read(localKey[:]); err != nil {
+		return err
+	}
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	passcodeKey := createTDesktopLocalKey([]byte(passcode), salt)
+	config := serializeTDesktopConfig()
+	if err := writeTDesktopSettings(dirname, config); err != nil {
+		return fmt.Errorf("couldn't write settings: %w", err)
+	}
+
+	// key_datas: salt, local key encrypted by the passcode, and the list of accounts.
+	var keyData bytes.Buffer
+	writeTDesktopArray(&keyData, salt)
+	encryptedKey, err := encryptTDesktopLocal(localKey[:], passcodeKey)
+	if err != nil {
+		return err
+	}
+	writeTDesktopArray(&keyData, encryptedKey)
+	var info bytes.Buffer
+	info.Write(binary.BigEndian.AppendUint32(nil, uint32(len(sessions))))
+	for i := range sessions {
+		info.Write(binary.BigEndian.AppendUint32(nil, uint32(i)))
+	}
+	// Active account.
+	info.Write(binary.BigEndian.AppendUint32(nil, 0))
+	encryptedInfo, err := encryptTDesktopLocal(info.Bytes(), localKey)
+	if err != nil {
+		return err
+	}
+	writeTDesktopArray(&keyData, encryptedInfo)
+	if err := writeTDesktopFile(filepath.Join(dirname, "key_datas"), keyData.Bytes()); err != nil {
+		return err
+	}
+
+	for i, s := range sessions {
+		if err := writeTDesktopAccount(dirname, i, s, localKey, config); err != nil {
+			return fmt.Errorf("couldn't write account %d: %w", s.UserID, err)
+		}
+	}
+	return nil
+}
+
+// writeTDesktopAccount writes the MTProto authorization of the account, its config and its empty map.
+func writeTDesktopAccount(dirname string, index int, s TDATASession, localKey crypto.Key, config []byte) error {
+	dataName := "data"
+	if index > 0 {
+		dataName = fmt.Sprintf("data#%d", index+1)
+	}
+	fileKey := tdesktopFileKey(dataName)
+
+	// | 8    | uint64 | Wide IDs tag  |
+	// | 8    | uint64 | User ID       |
+	// | 4    | uint32 | Main DC ID    |
+	// | 4    | uint32 | Keys count    |
+	// | 4    | uint32 | DC ID         |
+	// | 256  | bytes  | Auth key      |
+	// | 4    | uint32 | Keys to destroy count |
+	var authorization bytes.Buffer
+	authorization.Write(binary.BigEndian.AppendUint64(nil, tdesktopWideIDsTag))
+	authorization.Write(binary.BigEndian.AppendUint64(nil, uint64(s.UserID)))
+	authorization.Write(binary.BigEndian.AppendUint32(nil, uint32(s.Data.DC)))
+	authorization.Write(binary.BigEndian.AppendUint32(nil, 1))
+	authorization.Write(binary.BigEndian.AppendUint32(nil, uint32(s.Data.DC)))
+	authorization.Write(s.Data.AuthKey)
+	authorization.Write(binary.BigEndian.AppendUint32(nil, 0))
+
+	var mtpData bytes.Buffer
+	mtpData.Write(binary.BigEndian.AppendUint32(nil, dbiMtpAuthorization))
+	writeTDesktopArray(&mtpData, authorization.Bytes())
+	encrypted, err := encryptTDesktopLocal(mtpData.Bytes(), localKey)
+	if err != nil {
+		return err
+	}
+	var mtpFile bytes.Buffer
+	writeTDesktopArray(&mtpFile, encrypted)
+	if err := writeTDesktopFile(filepath.Join(dirname, fileKey+"s"), mtpFile.Bytes()); err != nil {
+		return err
+	}
+
+	// The map lists the cached local files of the account, there is none yet.
+	// It starts with the legacy salt and key, which are null.
+	accountDir := filepath.Join(dirname, fileKey)
+	if err := os.MkdirAll(accountDir, 0o700); err != nil {
+		return err
+	}
+	var mapFile bytes.Buffer
+	writeTDesktopArray(&mapFile, nil)
+	writeTDesktopArray(&mapFile, nil)
+	encryptedMap, err := encryptTDesktopLocal(nil, localKey)
+	if err != nil {
+		return err
+	}
+	writeTDesktopArray(&mapFile, encryptedMap)
+	if err := writeTDesktopFile(filepath.Join(accountDir, "maps"), mapFile.Bytes()); err != nil {
+		return err
+	}
+
+	var configData bytes.Buffer
+	writeTDesktopArray(&configData, config)
+	encryptedConfig, err := encryptTDesktopLocal(configData.Bytes(), localKey)
+	if err != nil {
+		return err
+	}
+	var configFile bytes.Buffer
+	writeTDesktopArray(&configFile, encryptedConfig)
+	return writeTDesktopFile(filepath.Join(accountDir, "configs"), configFile.Bytes())
+}
+
+// writeTDesktopSettings writes the settings file shared by the accounts, with default values
+// modelled on the blocks of Telegram Desktop's settings scheme.
+// See https://github.com/telegramdesktop/tdesktop/blob/dev/Telegram/SourceFiles/storage/localstorage.cpp
+func writeTDesktopSettings(dirname string, config []byte) error {
+	salt := make([]byte, tdesktopSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	var settingsKey crypto.Key
+	copy(settingsKey[:], pbkdf2.Key(nil, salt, tdesktopSettingsIterationCount, len(settingsKey), sha1.New))
+
+	var settings bytes.Buffer
+	for _, block := range []struct {
+		id    uint32
+		value uint32
+	}{
+		{id: dbiAutoStart, value: 0},
+		{id: dbiStartMinimized, value: 0},
+		{id: dbiSendToMenu, value: 0},
+		{id: dbiSeenTrayTooltip, value: 0},
+		{id: dbiAutoUpdate, value: 1},
+		{id: dbiLastUpdateCheck, value: 0},
+		// 0 is the automatic scale.
+		{id: dbiScalePercent, value: 0},
+	} {
+		settings.Write(binary.BigEndian.AppendUint32(nil, block.id))
+		settings.Write(binary.BigEndian.AppendUint32(nil, block.value))
+	}
+	settings.Write(binary.BigEndian.AppendUint32(nil, dbiFallbackProductionConfig))
+	writeTDesktopArray(&settings, config)
+	settings.Write(binary.BigEndian.AppendUint32(nil, dbiAnimationsDisabled))
+	settings.Write(binary.BigEndian.AppendUint32(nil, 0))
+
+	encrypted, err := encryptTDesktopLocal(settings.Bytes(), settingsKey)
+	if err != nil {
+		return err
+	}
+	var settingsFile bytes.Buffer
+	writeTDesktopArray(&settingsFile, salt)
+	writeTDesktopArray(&settingsFile, encrypted)
+	return writeTDesktopFile(filepath.Join(dirname, "settingss"), settingsFile.Bytes())
+}
+
+// serializeTDesktopConfig serializes the production MTP::Config, with the default values of
+// Telegram Desktop and gotd's production DC options.
+// See https://github.com/telegramdesktop/tdesktop/blob/dev/Telegram/SourceFiles/mtproto/mtproto_config.cpp
+func serializeTDesktopConfig() []byte {
+	var options bytes.Buffer
+	// A negative count gives the version, the count follows.
+	version := int32(-tdesktopDCOptionsVersion)
+	options.Write(binary.BigEndian.AppendUint32(nil, uint32(version)))
+	dcOptions := dcs.Prod().Options
+	options.Write(binary.BigEndian.AppendUint32(nil, uint32(len(dcOptions))))
+	for _, option := range dcOptions {
+		option.SetFlags()
+		options.Write(binary.BigEndian.AppendUint32(nil, uint32(option.ID)))
+		options.Write(binary.BigEndian.AppendUint32(nil, uint32(option.Flags)))
+		options.Write(binary.BigEndian.AppendUint32(nil, uint32(option.Port)))
+		writeTDesktopArray(&options, []byte(option.IPAddress))
+		writeTDesktopArray(&options, append([]byte{}, option.Secret...))
+	}
+	// CDN public keys.
+	options.Write(binary.BigEndian.AppendUint32(nil, 0))
+
+	var config bytes.Buffer
+	writeInt := func(values ...int32) {
+		for _, v := range values {
+			config.Write(binary.BigEndian.AppendUint32(nil, uint32(v)))
+		}
+	}
+	// Version and production environment.
+	writeInt(tdesktopConfigVersion, 0)
+	writeTDesktopArray(&config, options.Bytes())
+	writeInt(
+		200,    // chatSizeMax
+		10000,  // megagroupSizeMax
+		100,    // forwardedCountMax
+		120000, // onlineUpdatePeriod
+		5000,   // offlineBlurTimeout
+		30000,  // offlineIdleTimeout
+		1000,   // onlineFocusTimeout
+		300000, // onlineCloudTimeout
+		30000,  // notifyCloudDelay
+		1500,   // notifyDefaultDelay
+		200,    // savedGifsLimit
+		172800, // editTimeLimit
+		172800, // revokeTimeLimit
+		172800, // revokePrivateTimeLimit
+		0,      // revokePrivateInbox
+		30,     // stickersRecentLimit
+		5,      // stickersFavedLimit
+		5,      // pinnedDialogsCountMax
+		100,    // pinnedDialogsInFolderMax
+	)
+	writeTDesktopString(&config, "https://t.me/")
+	writeInt(
+		86400*7, // channelsReadMediaPeriod
+		20000,   // callReceiveTimeoutMs
+		90000,   // callRingTimeoutMs
+		30000,   // callConnectTimeoutMs
+		10000,   // callPacketTimeoutMs
+		4,       // webFileDcId
+	)
+	writeTDesktopString(&config, "")
+	writeInt(
+		1,    // phoneCallsEnabled
+		0,    // blockedMode
+		1024, // captionLengthMax
+	)
+	return config.Bytes()
+}
+
+// tdesktopFileKey returns the name used by Telegram Desktop for the given data name.
+func tdesktopFileKey(name string) string {
+	hash := md5.Sum([]byte(name))
+	for i := range hash {
+		hash[i] = hash[i]<<4 | hash[i]>>4
+	}
+	return strings.ToUpper(hex.EncodeToString(hash[:]))[:16]
+}
+
+func createTDesktopLocalKey(passcode, salt []byte) (key crypto.Key) {
+	iterations := 1
+	if len(passcode) > 0 {
+		iterations = tdesktopIterationCount
+	}
+	h := sha512.New()
+	h.Write(salt)
+	h.Write(passcode)
+	h.Write(salt)
+	copy(key[:], pbkdf2.Key(h.Sum(nil), salt, iterations, len(key), sha512.New))
+	return key
+}
+
+// encryptTDesktopLocal prefixes the data with its length, pads it, and encrypts it with the key.
+func encryptTDesktopLocal(data []byte, key crypto.Key) ([]byte, error) {
+	size := 4 + len(data)
+	fullSize := size
+	if fullSize%aes.BlockSize != 0 {
+		fullSize += aes.BlockSize - fullSize%aes.BlockSize
+	}
+	decrypted := make([]byte, fullSize)
+	binary.LittleEndian.PutUint32(decrypted, uint32(size))
+	copy(decrypted[4:], data)
+	if _, err := rand.Read(decrypted[size:]); err != nil {
+		return nil, err
+	}
+
+	var msgKey bin.Int128
+	hash := sha1.Sum(decrypted)
+	copy(msgKey[:], hash[:])
+	aesKey, aesIV := crypto.OldKeys(key, msgKey, crypto.Server)
+	cipher, err := aes.NewCipher(aesKey[:])
+	if err != nil {
+		return nil, err
+	}
+	encrypted := make([]byte, 16+fullSize)
+	copy(encrypted, msgKey[:])
+	ige.EncryptBlocks(cipher, aesIV[:], encrypted[16:], decrypted)
+	return encrypted, nil
+}
+
+// writeTDesktopArray writes a QByteArray, a nil array is written as a null one.
+func writeTDesktopArray(buf *bytes.Buffer, data []byte) {
+	if data == nil {
+		buf.Write(binary.BigEndian.AppendUint32(nil, 0xffffffff))
+		return
+	}
+	buf.Write(binary.BigEndian.AppendUint32(nil, uint32(len(data))))
+	buf.Write(data)
+}
+
+// writeTDesktopString writes a QString, encoded in UTF-16 big endian.
+func writeTDesktopString(buf *bytes.Buffer, s string) {
+	encoded := utf16.Encode([]rune(s))
+	buf.Write(binary.BigEndian.AppendUint32(nil, uint32(2*len(encoded))))
+	for _, c := range encoded {
+		buf.Write(binary.BigEndian.AppendUint16(nil, c))
+	}
+}
+
+func writeTDesktopFile(path string, data []byte) error {
+	version := binary.LittleEndian.AppendUint32(nil, tdesktopFileVersion)
+
+	var buf bytes.Buffer
+	buf.Write(tdesktopFileMagic)
+	buf.Write(version)
+	buf.Write(data)
+	h := md5.New()
+	h.Write(data)
+	h.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(data))))
+	h.Write(version)
+	h.Write(tdesktopFileMagic)
+	buf.Write(h.Sum(nil))
+
+	return os.WriteFile(path, buf.Bytes(), 0o600)
+}
@@ -0,0 +1,149 @@
+package session
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/sha1"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gotd/ige"
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/crypto"
+	"github.com/gotd/td/session"
+	"github.com/gotd/td/session/tdesktop"
+	"github.com/gotd/td/telegram/dcs"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+func TestWriteTDATA(t *testing.T) {
+	for _, passcode := range []string{"", "passcode"} {
+		dirname := t.TempDir()
+		secondKey := testAuthKey()
+		secondKey[0] = 0xff
+		err := WriteTDATA(dirname, passcode,
+			TDATASession{UserID: 7513073974, Data: &session.Data{DC: 2, AuthKey: testAuthKey()}},
+			TDATASession{UserID: 123456, Data: &session.Data{DC: 4, AuthKey: secondKey}},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		accounts, err := ConvertTDATAToTelethonStringSessions(dirname, passcode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(accounts) != 2 {
+			t.Fatalf("unexpected number of accounts: %d", len(accounts))
+		}
+		for i, expected := range []struct {
+			userID  int64
+			dc      int
+			authKey []byte
+		}{
+			{userID: 7513073974, dc: 2, authKey: testAuthKey()},
+			{userID: 123456, dc: 4, authKey: secondKey},
+		} {
+			if accounts[i].UserID != expected.userID || accounts[i].DC != expected.dc {
+				t.Fatalf("unexpected account: %+v", accounts[i])
+			}
+			data, err := ParseTelethonSession(accounts[i].SessionString)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data.AuthKey, expected.authKey) {
+				t.Fatalf("auth key mismatch for account %d", i)
+			}
+		}
+
+		if passcode != "" {
+			if _, err := ConvertTDATAToTelethonStringSessions(dirname, ""); err == nil {
+				t.Fatal("expected an error without the passcode")
+			}
+		}
+	}
+}
+
+func TestWriteTDATAConfig(t *testing.T) {
+	dirname := t.TempDir()
+	err := WriteTDATA(dirname, "", TDATASession{UserID: 7513073974, Data: &session.Data{DC: 2, AuthKey: testAuthKey()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accounts, err := tdesktop.Read(dirname, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := accounts[0].Config
+	if config.Environment.Test() || config.WebFileDCID != 4 || config.CaptionLengthMax != 1024 || !config.PhoneCallsEnabled {
+		t.Fatalf("unexpected config: %+v", config)
+	}
+	options := config.DCOptions.Options
+	if len(options) != len(dcs.Prod().Options) {
+		t.Fatalf("unexpected number of DC options: %d", len(options))
+	}
+	if options[0].ID != 1 || options[0].IP != dcs.Prod().Options[0].IPAddress {
+		t.Fatalf("unexpected DC option: %+v", options[0])
+	}
+
+	// The settings are encrypted with the key of an empty passcode.
+	raw, err := os.ReadFile(filepath.Join(dirname, "settingss"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(raw, tdesktopFileMagic) {
+		t.Fatal("missing settings magic")
+	}
+	data := raw[8 : len(raw)-16]
+	salt, data := readTestTDesktopArray(t, data)
+	encrypted, _ := readTestTDesktopArray(t, data)
+	var key crypto.Key
+	copy(key[:], pbkdf2.Key(nil, salt, tdesktopSettingsIterationCount, len(key), sha1.New))
+	settings := decryptTestTDesktopLocal(t, encrypted, key)
+
+	var blocks []uint32
+	for len(settings) > 0 {
+		block := binary.BigEndian.Uint32(settings)
+		blocks = append(blocks, block)
+		settings = settings[4:]
+		if block == dbiFallbackProductionConfig {
+			var fallback []byte
+			fallback, settings = readTestTDesktopArray(t, settings)
+			if !bytes.Equal(fallback, serializeTDesktopConfig()) {
+				t.Fatal("fallback config mismatch")
+			}
+			continue
+		}
+		settings = settings[4:]
+	}
+	if len(blocks) != 9 || blocks[0] != dbiAutoStart || blocks[8] != dbiAnimationsDisabled {
+		t.Fatalf("unexpected settings blocks: %x", blocks)
+	}
+}
+
+func readTestTDesktopArray(t *testing.T, data []byte) (array, rest []byte) {
+	t.Helper()
+	size := binary.BigEndian.Uint32(data)
+	if int(size) > len(data)-4 {
+		t.Fatalf("invalid array size: %d", size)
+	}
+	return data[4 : 4+size], data[4+size:]
+}
+
+func decryptTestTDesktopLocal(t *testing.T, encrypted []byte, key crypto.Key) []byte {
+	t.Helper()
+	var msgKey bin.Int128
+	copy(msgKey[:], encrypted)
+	aesKey, aesIV := crypto.OldKeys(key, msgKey, crypto.Server)
+	cipher, err := aes.NewCipher(aesKey[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted := make([]byte, len(encrypted)-16)
+	ige.DecryptBlocks(cipher, aesIV[:], decrypted, encrypted[16:])
+	size := binary.LittleEndian.Uint32(decrypted)
+	return decrypted[4:size]
+}
@@ -0,0 +1,301 @@
+package session
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gotd/td/crypto"
+	"github.com/gotd/td/session"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// TDLib binlog format.
+// See https://github.com/tdlib/td/blob/master/tddb/td/db/binlog/BinlogEvent.h
+const (
+	// | 4 | uint32 | Size  |
+	// | 8 | uint64 | ID    |
+	// | 4 | int32  | Type  |
+	// | 4 | int32  | Flags |
+	// | 8 | uint64 | Extra |
+	tdlibEventHeaderSize = 4 + 8 + 4 + 4 + 8
+	// | 4 | uint32 | CRC32 |
+	tdlibEventTailSize = 4
+	tdlibEventMaxSize  = 1 << 24
+
+	tdlibBinlogName = "td.binlog"
+
+	// Service events, see BinlogEvent::ServiceTypes.
+	tdlibHeaderEvent           = -1
+	tdlibEmptyEvent            = -2
+	tdlibAesCtrEncryptionEvent = -3
+	tdlibNoEncryptionEvent     = -4
+
+	tdlibBinlogPmcEvent     = 0x4327
+	tdlibRewriteFlag        = 1
+	tdlibKeyIterationCount  = 2
+	tdlibAuthKeyAuthorized  = 1
+	tdlibEncryptionKeyHash  = "cucumbers everywhere"
+	tdlibEncryptionKeyBytes = 32
+	// TDLib encrypts the database with this key when database_encryption_key is empty.
+	tdlibDefaultEncryptionKey = "cucumber"
+)
+
+var TDLibEncryptedError = errors.New("tdlib binlog is encrypted, the database encryption key is required")
+
+// TDLibAccount is the account found in a TDLib database.
+type TDLibAccount struct {
+	UserID int64
+	Data   *session.Data
+}
+
+// ReadTDLibBinlog reads the auth key of the main DC from a TDLib `td.binlog` file.
+// The encryption key is the `database_encryption_key` given to TDLib, leave it empty if none was
+// given: TDLib's default key is used, and a TDLibEncryptedError is returned if it doesn't match.
+func ReadTDLibBinlog(binlogPath string, encryptionKey []byte) (*TDLibAccount, error) {
+	content, err := os.ReadFile(binlogPath)
+	if err != nil {
+		return nil, err
+	}
+	values, err := readTDLibKeyValues(content, encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	dc, err := strconv.Atoi(string(values["main_dc_id"]))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't find the main DC in the binlog: %w", err)
+	}
+	authKey, err := parseTDLibAuthKey(values["auth"+strconv.Itoa(dc)])
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the auth key of DC %d: %w", dc, err)
+	}
+	addr, err := getDCAddress(dc, false)
+	if err != nil {
+		return nil, err
+	}
+	var key crypto.Key
+	copy(key[:], authKey)
+	id := key.WithID().ID
+
+	// The user ID is only stored once the account is logged in.
+	userID, _ := strconv.ParseInt(string(values["my_id"]), 10, 64)
+	return &TDLibAccount{
+		UserID: userID,
+		Data: &session.Data{
+			DC:        dc,
+			Addr:      addr,
+			AuthKey:   key[:],
+			AuthKeyID: id[:],
+		},
+	}, nil
+}
+
+// TDLibBinlogPath returns the path of the binlog of a TDLib database, the source itself if it's
+// already a binlog file.
+func TDLibBinlogPath(source string) string {
+	if info, err := os.Stat(source); err == nil && info.IsDir() {
+		return filepath.Join(source, tdlibBinlogName)
+	}
+	return source
+}
+
+// ConvertTDLibBinlogToTelethonStringSession converts the account of a TDLib `td.binlog`
+// file to a Telethon string session.
+func ConvertTDLibBinlogToTelethonStringSession(binlogPath string, encryptionKey []byte) (string, error) {
+	account, err := ReadTDLibBinlog(binlogPath, encryptionKey)
+	if err != nil {
+		return "", err
+	}
+	return EncodeSessionToTelethonString(account.Data)
+}
+
+// isTDLibBinlog reports whether the content is a TDLib binlog. Binlogs have no magic number, so
+// the first event, usually the header or the encryption event, must have a valid size and
+// checksum.
+func isTDLibBinlog(content []byte) bool {
+	if len(content) < tdlibEventHeaderSize+tdlibEventTailSize {
+		return false
+	}
+	size := int(binary.LittleEndian.Uint32(content))
+	if size < tdlibEventHeaderSize+tdlibEventTailSize || size > len(content) || size%4 != 0 {
+		return false
+	}
+	return crc32.ChecksumIEEE(content[:size-tdlibEventTailSize]) == binary.LittleEndian.Uint32(content[size-tdlibEventTailSize:])
+}
+
+// readTDLibKeyValues replays the binlog and returns the binlog key-value store, where TDLib
+// keeps its auth keys.
+// The content is decrypted in place.
+func readTDLibKeyValues(content []byte, encryptionKey []byte) (map[string][]byte, error) {
+	type keyValue struct {
+		key   string
+		value []byte
+	}
+	events := make(map[uint64]keyValue)
+
+	for offset := 0; offset < len(content); {
+		if len(content)-offset < tdlibEventHeaderSize+tdlibEventTailSize {
+			// TDLib may leave a partially written event at the end.
+			break
+		}
+		size := int(binary.LittleEndian.Uint32(content[offset:]))
+		if size < tdlibEventHeaderSize+tdlibEventTailSize || size > tdlibEventMaxSize {
+			return nil, fmt.Errorf("invalid binlog event size %d at offset %d", size, offset)
+		}
+		if offset+size > len(content) {
+			break
+		}
+		event := content[offset : offset+size]
+		offset += size
+		if crc32.ChecksumIEEE(event[:size-tdlibEventTailSize]) != binary.LittleEndian.Uint32(event[size-tdlibEventTailSize:]) {
+			return nil, fmt.Errorf("binlog event at offset %d has an invalid checksum", offset-size)
+		}
+
+		id := binary.LittleEndian.Uint64(event[4:])
+		eventType := int32(binary.LittleEndian.Uint32(event[12:]))
+		flags := int32(binary.LittleEndian.Uint32(event[16:]))
+		data := event[tdlibEventHeaderSize : size-tdlibEventTailSize]
+
+		switch eventType {
+		case tdlibAesCtrEncryptionEvent:
+			// Everything after this event is encrypted.
+			stream, err := newTDLibDecrypter(data, encryptionKey)
+			if err != nil {
+				return nil, err
+			}
+			stream.XORKeyStream(content[offset:], content[offset:])
+		case tdlibEmptyEvent:
+			if flags&tdlibRewriteFlag != 0 {
+				delete(events, id)
+			}
+		case tdlibBinlogPmcEvent:
+			r := bytes.NewReader(data)
+			key, err := readTLString(r)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't read binlog key: %w", err)
+			}
+			value, err := readTLString(r)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't read binlog value: %w", err)
+			}
+			events[id] = keyValue{key: string(key), value: value}
+		}
+	}
+
+	values := make(map[string][]byte, len(events))
+	for _, event := range events {
+		values[event.key] = event.value
+	}
+	return values, nil
+}
+
+// newTDLibDecrypter returns the AES-CTR stream described by the encryption event, TDLib's
+// default key is used if the encryption key is empty.
+func newTDLibDecrypter(data []byte, encryptionKey []byte) (cipher.Stream, error) {
+	defaultKey := len(encryptionKey) == 0
+	if defaultKey {
+		encryptionKey = []byte(tdlibDefaultEncryptionKey)
+	}
+	// | 4 | int32  | Flags    |
+	// | n | string | Key salt |
+	// | n | string | IV       |
+	// | n | string | Key hash |
+	r := bytes.NewReader(data)
+	if _, err := r.Seek(4, io.SeekStart); err != nil {
+		return nil, err
+	}
+	salt, err := readTLString(r)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read key salt: %w", err)
+	}
+	iv, err := readTLString(r)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read IV: %w", err)
+	}
+	keyHash, err := readTLString(r)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read key hash: %w", err)
+	}
+
+	key := pbkdf2.Key(encryptionKey, salt, tdlibKeyIterationCount, tdlibEncryptionKeyBytes, sha256.New)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(tdlibEncryptionKeyHash))
+	if !hmac.Equal(mac.Sum(nil), keyHash) {
+		if defaultKey {
+			return nil, TDLibEncryptedError
+		}
+		return nil, errors.New("wrong tdlib database encryption key")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("invalid IV length: %d", len(iv))
+	}
+	return cipher.NewCTR(block, iv), nil
+}
+
+// parseTDLibAuthKey reads the auth key serialized by TDLib's `mtproto::AuthKey`.
+func parseTDLibAuthKey(data []byte) ([]byte, error) {
+	// | 8 | uint64 | Auth key ID |
+	// | 4 | int32  | Flags       |
+	// | n | string | Auth key    |
+	if len(data) < 12 {
+		return nil, errors.New("auth key not found")
+	}
+	flags := int32(binary.LittleEndian.Uint32(data[8:]))
+	if flags&tdlibAuthKeyAuthorized == 0 {
+		return nil, errors.New("auth key is not authorized")
+	}
+	key, err := readTLString(bytes.NewReader(data[12:]))
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 256 {
+		return nil, fmt.Errorf("invalid auth key length: %d", len(key))
+	}
+	return key, nil
+}
+
+// readTLString reads a TL serialized string, padded to 4 bytes.
+func readTLString(r *bytes.Reader) ([]byte, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length := int(first)
+	headerSize := 1
+	if first == 254 {
+		var buf [3]byte
+		if _, err := r.Read(buf[:]); err != nil {
+			return nil, err
+		}
+		length = int(buf[0]) | int(buf[1])<<8 | int(buf[2])<<16
+		headerSize = 4
+	}
+	if length > r.Len() {
+		return nil, fmt.Errorf("string length %d exceeds the remaining %d bytes", length, r.Len())
+	}
+	value := make([]byte, length)
+	if _, err := r.Read(value); err != nil {
+		return nil, err
+	}
+	if padding := (4 - (headerSize+length)%4) % 4; padding > 0 {
+		if _, err := r.Seek(int64(padding), io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
@@ -0,0 +1,80 @@
+package session
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The fixtures contain the keys of DC 2 and DC 4, with the main DC rewritten from 4 to 2, and the
+// DC 4 key erased afterwards, laid out like TDLib's Binlog.cpp writes them:
+// testdata/td_unencrypted.binlog contains the events without encryption,
+// testdata/td.binlog starts with an AesCtrEncryption event for TDLib's default key "cucumber",
+// testdata/td_encrypted.binlog for the key "secret".
+func TestReadTDLibBinlog(t *testing.T) {
+	for _, test := range []struct {
+		path string
+		key  []byte
+	}{
+		{path: "testdata/td_unencrypted.binlog"},
+		{path: "testdata/td.binlog"},
+		{path: "testdata/td_encrypted.binlog", key: []byte("secret")},
+	} {
+		t.Run(test.path, func(t *testing.T) {
+			account, err := ReadTDLibBinlog(test.path, test.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if account.UserID != 7513073974 || account.Data.DC != 2 {
+				t.Fatalf("unexpected account: user %d, DC %d", account.UserID, account.Data.DC)
+			}
+			if hex.EncodeToString(account.Data.AuthKeyID) != "ffe228197b518093" {
+				t.Fatalf("unexpected auth key ID: %x", account.Data.AuthKeyID)
+			}
+			if _, err := ConvertTDLibBinlogToTelethonStringSession(test.path, test.key); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	if _, err := ReadTDLibBinlog("testdata/td_encrypted.binlog", nil); !errors.Is(err, TDLibEncryptedError) {
+		t.Fatalf("expected TDLibEncryptedError, got %v", err)
+	}
+	if _, err := ReadTDLibBinlog("testdata/td_encrypted.binlog", []byte("wrong")); err == nil {
+		t.Fatal("expected an error with the wrong key")
+	}
+}
+
+func TestDetectTDLib(t *testing.T) {
+	// The binlog is detected by its content, whatever its name.
+	content, err := os.ReadFile("testdata/td_encrypted.binlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	renamed := filepath.Join(t.TempDir(), "account.binlog")
+	if err := os.WriteFile(renamed, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, source := range []string{"testdata/td.binlog", "testdata/td_unencrypted.binlog", renamed} {
+		format, err := Detect(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if format != FormatTDLib {
+			t.Fatalf("%s: detected %q instead of %q", source, format, FormatTDLib)
+		}
+	}
+
+	if _, err := Open(renamed); !errors.Is(err, TDLibEncryptedError) {
+		t.Fatalf("expected TDLibEncryptedError, got %v", err)
+	}
+	opened, err := OpenWithOptions(renamed, OpenOptions{EncryptionKey: []byte("secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.DC != 2 {
+		t.Fatalf("unexpected DC: %d", opened.DC)
+	}
+}
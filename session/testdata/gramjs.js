@@ -0,0 +1,49 @@
+// Generates gramjs.json with GramJS's own StringSession.save(), so the GramJS codec is tested
+// against the real library, not against itself. Run from this folder:
+//
+//	npm install --no-save telegram
+//	node gramjs.js > gramjs.json
+//
+// The version of the telegram package is printed on stderr, note it in gramjs_test.go.
+const crypto = require("crypto");
+const { StringSession } = require("telegram/sessions");
+const { AuthKey } = require("telegram/crypto/AuthKey");
+
+const servers = [
+  { dc: 2, addr: "149.154.167.51", port: 443 },
+  { dc: 4, addr: "149.154.167.91", port: 443 },
+  // Web clients store the hostname of the DC.
+  { dc: 1, addr: "pluto.web.telegram.org", port: 443 },
+  { dc: 5, addr: "2001:b28:f23f:f005::a", port: 443 },
+  { dc: 3, addr: "149.154.175.117", port: 80 },
+];
+
+async function main() {
+  const vectors = [];
+  for (const { dc, addr, port } of servers) {
+    const key = Buffer.alloc(256);
+    for (let i = 0; i < key.length; i++) {
+      key[i] = (i * 7 + dc) & 0xff;
+    }
+    const authKey = new AuthKey();
+    await authKey.setKey(key);
+    const session = new StringSession("");
+    session.setDC(dc, addr, port);
+    session.authKey = authKey;
+    vectors.push({
+      session: session.save(),
+      dc,
+      addr,
+      port,
+      // Like gotd, the last 8 bytes of the SHA-1 of the key.
+      auth_key_id: crypto.createHash("sha1").update(key).digest().subarray(12, 20).toString("hex"),
+    });
+  }
+  console.error("telegram@" + require("telegram/package.json").version);
+  console.log(JSON.stringify(vectors, null, 2));
+}
+
+main().catch((err) => {
+  console.error(err);
+  process.exit(1);
+});
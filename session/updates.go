@@ -0,0 +1,272 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/telegram/updates"
+)
+
+var _ updates.StateStorage = (*UpdatesStorage)(nil)
+var _ updates.ChannelAccessHasher = (*UpdatesStorage)(nil)
+
+// DefaultUpdatesSaveInterval is how long UpdatesStorage waits before writing the changes of the
+// state to its file, so the file isn't rewritten on every update.
+const DefaultUpdatesSaveInterval = time.Second
+
+// UpdatesStorage implements gotd's `updates.StateStorage` and `updates.ChannelAccessHasher`
+// in memory, and in the JSON file at Path when it is set, so the updates missed while the
+// client was stopped are fetched on the next start.
+// The changes are written at most once per SaveInterval, call Flush to write them at once.
+// Connect does when the client stops.
+// Goroutine-safe.
+type UpdatesStorage struct {
+	Path string
+	// SaveInterval is DefaultUpdatesSaveInterval by default.
+	SaveInterval time.Duration
+
+	mux    sync.Mutex
+	loaded bool
+	users  map[int64]*userUpdatesState
+	// dirty is set when the state changed since it was written.
+	dirty bool
+	// timer writes the changes, nil when no write is scheduled.
+	timer *time.Timer
+	// saveErr is the error of the last write in the background, returned by the next call.
+	saveErr error
+}
+
+type userUpdatesState struct {
+	State    *updates.State          `json:"state,omitempty"`
+	Channels map[int64]*channelState `json:"channels"`
+}
+
+type channelState struct {
+	Pts        *int   `json:"pts,omitempty"`
+	AccessHash *int64 `json:"access_hash,omitempty"`
+}
+
+func (s *UpdatesStorage) GetState(ctx context.Context, userID int64) (updates.State, bool, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return updates.State{}, false, err
+	}
+	if user.State == nil {
+		return updates.State{}, false, nil
+	}
+	return *user.State, true, nil
+}
+
+func (s *UpdatesStorage) SetState(ctx context.Context, userID int64, state updates.State) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+	user.State = &state
+	// The channels are fetched again when the whole state is reset, access hashes are kept.
+	for _, channel := range user.Channels {
+		channel.Pts = nil
+	}
+	return s.save()
+}
+
+func (s *UpdatesStorage) SetPts(ctx context.Context, userID int64, pts int) error {
+	return s.updateState(userID, func(state *updates.State) { state.Pts = pts })
+}
+
+func (s *UpdatesStorage) SetQts(ctx context.Context, userID int64, qts int) error {
+	return s.updateState(userID, func(state *updates.State) { state.Qts = qts })
+}
+
+func (s *UpdatesStorage) SetDate(ctx context.Context, userID int64, date int) error {
+	return s.updateState(userID, func(state *updates.State) { state.Date = date })
+}
+
+func (s *UpdatesStorage) SetSeq(ctx context.Context, userID int64, seq int) error {
+	return s.updateState(userID, func(state *updates.State) { state.Seq = seq })
+}
+
+func (s *UpdatesStorage) SetDateSeq(ctx context.Context, userID int64, date, seq int) error {
+	return s.updateState(userID, func(state *updates.State) {
+		state.Date = date
+		state.Seq = seq
+	})
+}
+
+func (s *UpdatesStorage) GetChannelPts(ctx context.Context, userID, channelID int64) (int, bool, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return 0, false, err
+	}
+	channel, ok := user.Channels[channelID]
+	if !ok || channel.Pts == nil {
+		return 0, false, nil
+	}
+	return *channel.Pts, true, nil
+}
+
+func (s *UpdatesStorage) SetChannelPts(ctx context.Context, userID, channelID int64, pts int) error {
+	return s.updateChannel(userID, channelID, func(channel *channelState) { channel.Pts = &pts })
+}
+
+func (s *UpdatesStorage) ForEachChannels(ctx context.Context, userID int64, f func(ctx context.Context, channelID int64, pts int) error) error {
+	s.mux.Lock()
+	user, err := s.user(userID)
+	if err != nil {
+		s.mux.Unlock()
+		return err
+	}
+	channels := make(map[int64]int, len(user.Channels))
+	for id, channel := range user.Channels {
+		if channel.Pts != nil {
+			channels[id] = *channel.Pts
+		}
+	}
+	s.mux.Unlock()
+
+	for id, pts := range channels {
+		if err := f(ctx, id, pts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *UpdatesStorage) SetChannelAccessHash(ctx context.Context, userID, channelID, accessHash int64) error {
+	return s.updateChannel(userID, channelID, func(channel *channelState) { channel.AccessHash = &accessHash })
+}
+
+func (s *UpdatesStorage) GetChannelAccessHash(ctx context.Context, userID, channelID int64) (int64, bool, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return 0, false, err
+	}
+	channel, ok := user.Channels[channelID]
+	if !ok || channel.AccessHash == nil {
+		return 0, false, nil
+	}
+	return *channel.AccessHash, true, nil
+}
+
+// updateState updates the state of the user, gotd expects an error if there is none.
+func (s *UpdatesStorage) updateState(userID int64, update func(state *updates.State)) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+	if user.State == nil {
+		return fmt.Errorf("no updates state for user %d", userID)
+	}
+	update(user.State)
+	return s.save()
+}
+
+func (s *UpdatesStorage) updateChannel(userID, channelID int64, update func(channel *channelState)) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+	channel, ok := user.Channels[channelID]
+	if !ok {
+		channel = &channelState{}
+		user.Channels[channelID] = channel
+	}
+	update(channel)
+	return s.save()
+}
+
+// user returns the state of the user, the file is read the first time.
+func (s *UpdatesStorage) user(userID int64) (*userUpdatesState, error) {
+	if !s.loaded {
+		s.users = make(map[int64]*userUpdatesState)
+		if s.Path != "" {
+			content, err := os.ReadFile(s.Path)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+			if len(content) > 0 {
+				if err := json.Unmarshal(content, &s.users); err != nil {
+					return nil, fmt.Errorf("couldn't read updates state from %s: %w", s.Path, err)
+				}
+			}
+		}
+		s.loaded = true
+	}
+	user, ok := s.users[userID]
+	if !ok {
+		user = &userUpdatesState{Channels: make(map[int64]*channelState)}
+		s.users[userID] = user
+	}
+	if user.Channels == nil {
+		user.Channels = make(map[int64]*channelState)
+	}
+	return user, nil
+}
+
+// Flush writes the changes of the state to the file, if there are any.
+func (s *UpdatesStorage) Flush() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	err := s.saveErr
+	s.saveErr = nil
+	return errors.Join(err, s.write())
+}
+
+// save schedules the write of the changes, the error of the previous write is returned.
+func (s *UpdatesStorage) save() error {
+	if s.Path == "" {
+		return nil
+	}
+	s.dirty = true
+	if s.timer == nil {
+		interval := s.SaveInterval
+		if interval == 0 {
+			interval = DefaultUpdatesSaveInterval
+		}
+		s.timer = time.AfterFunc(interval, func() {
+			s.mux.Lock()
+			defer s.mux.Unlock()
+			s.saveErr = s.write()
+		})
+	}
+	err := s.saveErr
+	s.saveErr = nil
+	return err
+}
+
+// write writes the state to the file, if it changed since the last write.
+func (s *UpdatesStorage) write() error {
+	s.timer = nil
+	if !s.dirty {
+		return nil
+	}
+	content, err := json.Marshal(s.users)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.Path, content); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
@@ -0,0 +1,77 @@
+package session
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/telegram/updates"
+)
+
+func TestUpdatesStorage(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "updates.json")
+	storage := &UpdatesStorage{Path: path}
+	if err := storage.SetPts(ctx, 1, 10); err == nil {
+		t.Fatal("expected an error without state")
+	}
+	if err := storage.SetState(ctx, 1, updates.State{Pts: 1, Qts: 2, Date: 3, Seq: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.SetDateSeq(ctx, 1, 5, 6); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.SetChannelPts(ctx, 1, 100, 7); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.SetChannelAccessHash(ctx, 1, 100, 8); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Resumed from the file.
+	storage = &UpdatesStorage{Path: path}
+	state, found, err := storage.GetState(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || state != (updates.State{Pts: 1, Qts: 2, Date: 5, Seq: 6}) {
+		t.Fatalf("unexpected state: %+v", state)
+	}
+	pts, found, err := storage.GetChannelPts(ctx, 1, 100)
+	if err != nil || !found || pts != 7 {
+		t.Fatalf("unexpected channel pts: %d, %v", pts, err)
+	}
+	hash, found, err := storage.GetChannelAccessHash(ctx, 1, 100)
+	if err != nil || !found || hash != 8 {
+		t.Fatalf("unexpected access hash: %d, %v", hash, err)
+	}
+}
+
+func TestUpdatesStorageBatchesWrites(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "updates.json")
+	storage := &UpdatesStorage{Path: path, SaveInterval: 100 * time.Millisecond}
+	if err := storage.SetState(ctx, 1, updates.State{Pts: 1}); err != nil {
+		t.Fatal(err)
+	}
+	for pts := 2; pts <= 100; pts++ {
+		if err := storage.SetPts(ctx, 1, pts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Nothing is written until the interval is elapsed.
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("the state was written at once: %v", err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	state, found, err := (&UpdatesStorage{Path: path}).GetState(ctx, 1)
+	if err != nil || !found || state.Pts != 100 {
+		t.Fatalf("unexpected state: %+v, %v", state, err)
+	}
+}
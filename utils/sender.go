@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"github.com/gotd/contrib/storage"
+	"github.com/gotd/td/telegram/message"
+	"github.com/gotd/td/telegram/message/peer"
+	"github.com/gotd/td/tg"
+)
+
+// SenderOption configures the senders created by NewSender.
+type SenderOption func(client *tg.Client, sender *message.Sender) *message.Sender
+
+// WithPeerStorage makes the sender look up usernames in the peer storage before resolving them,
+// and store the peers there once resolved. A nil storage is ignored.
+func WithPeerStorage(peers storage.PeerStorage) SenderOption {
+	return func(client *tg.Client, sender *message.Sender) *message.Sender {
+		if peers == nil {
+			return sender
+		}
+		return sender.WithResolver(storage.NewResolverCache(peer.Plain(client), peers))
+	}
+}
+
+// NewSender creates a new message sender, configured by the options.
+func NewSender(client *tg.Client, opts ...SenderOption) *message.Sender {
+	sender := message.NewSender(client)
+	for _, opt := range opts {
+		sender = opt(client, sender)
+	}
+	return sender
+}